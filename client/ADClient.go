@@ -60,6 +60,35 @@ type Client struct {
 	ADGroup  ADGroupService
 	ADOU     ADOUService
 	ADObject ADObjectService
+	ADPosix  ADPosixService
+
+	ADComputer ADComputerService
+}
+
+// NewClient returns a client for the given server with every service wired
+// up. The services call into each other, so a client built by hand has to set
+// all of them.
+func NewClient(host string, port int, domain, user, password string, useTLS, insecure bool) *Client {
+	c := &Client{
+		client: &Conn{
+			host:     host,
+			port:     port,
+			domain:   domain,
+			useTLS:   useTLS,
+			insecure: insecure,
+			user:     user,
+			password: password,
+		},
+	}
+
+	c.ADUser = &ADUserServiceOp{client: c}
+	c.ADGroup = &ADGroupServiceOp{client: c}
+	c.ADOU = &ADOUServiceOp{client: c}
+	c.ADObject = &ADObjectServiceOp{client: c}
+	c.ADPosix = &ADPosixServiceOp{client: c}
+	c.ADComputer = &ADComputerServiceOp{client: c}
+
+	return c
 }
 
 type Conn struct {
@@ -95,7 +124,7 @@ func (c *Client) connect() (*ldap.Conn, error) {
 		return nil, fmt.Errorf("connect - failed to connect: %s", err)
 	}
 
-	log.Infof("Checking if tls connection is enabled %s", c.client.useTLS)
+	log.Infof("Checking if tls connection is enabled %t", c.client.useTLS)
 
 	//Note - Please provide the fqdn here ..
 	ldapConfig := &tls.Config{InsecureSkipVerify: true, ServerName: c.client.host}
@@ -105,11 +134,7 @@ func (c *Client) connect() (*ldap.Conn, error) {
 		return nil, fmt.Errorf("connect - failed to use secure connection: %s", err)
 	}
 
-	user := c.client.user
-	if ok, e := regexp.MatchString(`.*,ou=.*`, c.client.user); e != nil || !ok {
-		user = fmt.Sprintf("%s@%s", c.client.user, c.client.domain)
-	}
-
+	user := c.bindUser()
 	log.Infof("Authenticating user %s.", user)
 	if err = client.Bind(user, c.client.password); err != nil {
 		client.Close()
@@ -120,6 +145,50 @@ func (c *Client) connect() (*ldap.Conn, error) {
 	return client, err
 }
 
+// returns the name to bind with, plain user names are qualified with the domain
+func (c *Client) bindUser() string {
+	if ok, e := regexp.MatchString(`.*,ou=.*`, c.client.user); e != nil || !ok {
+		return fmt.Sprintf("%s@%s", c.client.user, c.client.domain)
+	}
+	return c.client.user
+}
+
+// global catalog port over tls, the plain port is 3268
+const globalCatalogTLSPort = 3269
+
+// opens a connection to the global catalog on the configured host and returns
+// it together with the dn of the forest root domain, the base for forest wide
+// searches. The host has to be a global catalog server.
+func (c *Client) globalCatalog() (*ldap.Conn, string, error) {
+	rootDSE, err := c.ADObject.getObject("", []string{"rootDomainNamingContext"})
+	if err != nil {
+		return nil, "", fmt.Errorf("globalCatalog - failed to read the forest root: %s", err)
+	}
+
+	if rootDSE == nil || rootDSE.value("rootDomainNamingContext") == "" {
+		return nil, "", fmt.Errorf("globalCatalog - the server did not return the forest root")
+	}
+
+	gcConfig := &tls.Config{InsecureSkipVerify: true, ServerName: c.client.host}
+	gc, err := ldap.DialTLS("tcp", fmt.Sprintf("%s:%d", c.client.host, globalCatalogTLSPort), gcConfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("globalCatalog - failed to connect to the global catalog on %s: %s", c.client.host, err)
+	}
+
+	if err := gc.Bind(c.bindUser(), c.client.password); err != nil {
+		gc.Close()
+		return nil, "", fmt.Errorf("globalCatalog - authentication failed: %s", err)
+	}
+
+	return gc, rootDSE.value("rootDomainNamingContext"), nil
+}
+
+// reports whether the ldap connection is protected by tls
+func (c *Client) isEncrypted() bool {
+	_, ok := c.client.conn.TLSConnectionState()
+	return ok
+}
+
 func (c *Client) getDomainDN() string {
 	tmp := strings.Split(c.client.domain, ".")
 	return strings.ToLower(fmt.Sprintf("dc=%s", strings.Join(tmp, ",dc=")))
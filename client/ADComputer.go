@@ -2,9 +2,12 @@ package client
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
 	"gopkg.in/ldap.v3"
 )
 
@@ -15,15 +18,50 @@ type ADComputer struct {
 	description string
 }
 
+// ADComputerRequest describes a computer account to pre-stage
 type ADComputerRequest struct {
-	group_name        string
-	group_base_ou     string
-	group_description string
+	CN          string
+	BaseOU      string
+	Description string
+
+	// defaults to <cn>.<domain>
+	DNSHostName string
+
+	// added to the default HOST/ and RestrictedKrbHost/ spns
+	ServicePrincipalNames []string
+
+	// defaults to EncryptionTypeAES128 | EncryptionTypeAES256
+	EncryptionTypes EncryptionTypes
+
+	// generated when empty
+	Password string
 }
 
+// EncryptionTypes is the msDS-SupportedEncryptionTypes bit field
+type EncryptionTypes int
+
+// kerberos encryption types
+const (
+	EncryptionTypeDESCBCCRC EncryptionTypes = 0x01
+	EncryptionTypeDESCBCMD5 EncryptionTypes = 0x02
+	EncryptionTypeRC4       EncryptionTypes = 0x04
+	EncryptionTypeAES128    EncryptionTypes = 0x08
+	EncryptionTypeAES256    EncryptionTypes = 0x10
+)
+
+const (
+	// computer names are limited by their netbios name
+	maxComputerNameLength = 15
+
+	// length of generated machine passwords, as used by windows
+	machinePasswordLength = 120
+)
+
 type ADComputerService interface {
 	getComputer(name string) (*ADComputer, error)
-	createComputer(cn, ou, description string) error
+	createComputer(req ADComputerRequest) (string, error)
+	resetComputerPassword(cn, password string) (string, error)
+	cleanupStaleComputers(opts StaleComputerOptions) ([]*StaleComputer, error)
 }
 
 type ADComputerServiceOp struct {
@@ -40,7 +78,7 @@ func (s *ADComputerServiceOp) getComputer(name string) (*ADComputer, error) {
 	attributes := []string{"cn", "description"}
 
 	// ldap filter
-	filter := fmt.Sprintf("(&(objectclass=computer)(name=%s))", name)
+	filter := fmt.Sprintf("(&(objectclass=computer)(name=%s))", ldap.EscapeFilter(name))
 
 	// trying to get ou object
 	ret, err := s.client.ADObject.searchObject(filter, domain, attributes)
@@ -57,38 +95,107 @@ func (s *ADComputerServiceOp) getComputer(name string) (*ADComputer, error) {
 	}
 
 	return &ADComputer{
-		name:        ret[0].attributes["cn"][0],
+		name:        ret[0].value("cn"),
 		dn:          ret[0].dn,
-		description: ret[0].attributes["description"][0],
+		description: ret[0].value("description"),
 	}, nil
 }
 
-// creates a new computer object
-func (s *ADComputerServiceOp) createComputer(cn, ou, description string) error {
-	log.Infof("Creating computer object %s in %s", cn, ou)
+// pre-stages a computer account that a machine can join with. The account
+// is created enabled with a random machine password, dNSHostName, HOST/ and
+// RestrictedKrbHost/ spns and the supported encryption types, all in a single
+// add. Returns the password for an offline join.
+func (s *ADComputerServiceOp) createComputer(req ADComputerRequest) (string, error) {
+	log.Infof("Creating computer object %s in %s", req.CN, req.BaseOU)
 
-	tmp, err := s.getComputer(cn)
+	if req.CN == "" || req.BaseOU == "" {
+		return "", fmt.Errorf("createComputer - cn and base ou are required")
+	}
+
+	if utf8.RuneCountInString(req.CN) > maxComputerNameLength {
+		return "", fmt.Errorf("createComputer - computer name %q is longer than %d characters", req.CN, maxComputerNameLength)
+	}
+
+	// active directory only accepts unicodePwd over an encrypted connection
+	if !s.client.isEncrypted() {
+		return "", fmt.Errorf("createComputer - setting the machine password requires an encrypted connection")
+	}
+
+	tmp, err := s.getComputer(req.CN)
 	if err != nil {
-		return fmt.Errorf("createComputer - talking to active directory failed: %s", err)
+		return "", fmt.Errorf("createComputer - talking to active directory failed: %s", err)
 	}
 
-	// there is already a computer object with the same name
 	if tmp != nil {
-		if tmp.name == cn && tmp.dn == fmt.Sprintf("cn=%s,%s", cn, ou) {
-			log.Infof("Computer object %s already exists, updating description", cn)
-			return s.updateComputerDescription(cn, ou, description)
+		return "", fmt.Errorf("createComputer - computer object %s already exists: %s", req.CN, tmp.dn)
+	}
+
+	password := req.Password
+	if password == "" {
+		if password, err = helper.GeneratePassword(nil, helper.PasswordOptions{Length: machinePasswordLength}); err != nil {
+			return "", fmt.Errorf("createComputer - %s", err)
 		}
+	}
+
+	encoded, err := helper.EncodePassword(password)
+	if err != nil {
+		return "", fmt.Errorf("createComputer - %s", err)
+	}
 
-		return fmt.Errorf("createComputer - computer object %s already exists in a different ou", cn)
+	dnsHostName := req.DNSHostName
+	if dnsHostName == "" {
+		dnsHostName = strings.ToLower(req.CN + "." + s.client.client.domain)
+	}
+
+	encryptionTypes := req.EncryptionTypes
+	if encryptionTypes == 0 {
+		encryptionTypes = EncryptionTypeAES128 | EncryptionTypeAES256
 	}
 
 	attributes := make(map[string][]string)
-	attributes["name"] = []string{cn}
-	attributes["sAMAccountName"] = []string{cn + "$"}
-	attributes["userAccountControl"] = []string{"4096"}
-	attributes["description"] = []string{description}
+	attributes["name"] = []string{req.CN}
+	attributes["sAMAccountName"] = []string{strings.ToUpper(req.CN) + "$"}
+	attributes["userAccountControl"] = []string{strconv.Itoa(int(UACWorkstationTrustAccount))}
+	attributes["dNSHostName"] = []string{dnsHostName}
+	attributes["servicePrincipalName"] = computerSPNs(req.CN, dnsHostName, req.ServicePrincipalNames)
+	attributes["msDS-SupportedEncryptionTypes"] = []string{strconv.Itoa(int(encryptionTypes))}
+	attributes["unicodePwd"] = []string{encoded}
+	if req.Description != "" {
+		attributes["description"] = []string{req.Description}
+	}
+
+	dn := fmt.Sprintf("CN=%s,%s", helper.EscapeDN(req.CN), req.BaseOU)
+	if err := s.client.ADObject.createObject(dn, []string{"computer"}, attributes); err != nil {
+		return "", fmt.Errorf("createComputer - %s", err)
+	}
+
+	log.Infof("Computer object %s created", dn)
+	return password, nil
+}
+
+// returns the default spns of a computer followed by the extra ones, without duplicates
+func computerSPNs(cn, dnsHostName string, extra []string) []string {
+	spns := []string{
+		"HOST/" + cn,
+		"HOST/" + dnsHostName,
+		"RestrictedKrbHost/" + cn,
+		"RestrictedKrbHost/" + dnsHostName,
+	}
+
+	for _, spn := range extra {
+		duplicate := false
+		for _, existing := range spns {
+			if strings.EqualFold(spn, existing) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			spns = append(spns, spn)
+		}
+	}
 
-	return s.client.ADObject.createObject(fmt.Sprintf("cn=%s,%s", cn, ou), []string{"computer"}, attributes)
+	return spns
 }
 
 // moves an existing computer object to a new ou
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+	"gopkg.in/ldap.v3"
+)
+
+// resets the machine password of a computer account so the machine can
+// rejoin with it. A random password is generated when none is given, the
+// password is returned.
+func (s *ADComputerServiceOp) resetComputerPassword(cn, password string) (string, error) {
+	log.Infof("Resetting password of computer %s", cn)
+
+	if !s.client.isEncrypted() {
+		return "", fmt.Errorf("resetComputerPassword - setting the machine password requires an encrypted connection")
+	}
+
+	tmp, err := s.getComputer(cn)
+	if err != nil {
+		return "", fmt.Errorf("resetComputerPassword - talking to active directory failed: %s", err)
+	}
+
+	if tmp == nil {
+		return "", fmt.Errorf("resetComputerPassword - computer object %s does not exist", cn)
+	}
+
+	if password == "" {
+		if password, err = helper.GeneratePassword(nil, helper.PasswordOptions{Length: machinePasswordLength}); err != nil {
+			return "", fmt.Errorf("resetComputerPassword - %s", err)
+		}
+	}
+
+	encoded, err := helper.EncodePassword(password)
+	if err != nil {
+		return "", fmt.Errorf("resetComputerPassword - %s", err)
+	}
+
+	req := ldap.NewModifyRequest(tmp.dn, nil)
+	req.Replace("unicodePwd", []string{encoded})
+	if err := s.client.client.conn.Modify(req); err != nil {
+		return "", fmt.Errorf("resetComputerPassword - failed to reset password of %s: %s", tmp.dn, passwordError(err))
+	}
+
+	log.Info("Computer password reset")
+	return password, nil
+}
+
+// generalized time as active directory writes it
+const generalizedTimeLayout = "20060102150405.0Z"
+
+// StaleComputerOptions controls stale computer cleanup
+type StaleComputerOptions struct {
+	// where to look, defaults to the whole domain
+	BaseDN string
+
+	// computers whose lastLogonTimestamp and pwdLastSet are both older are stale
+	Threshold time.Duration
+
+	// disable stale computers
+	Disable bool
+
+	// move stale computers into this ou
+	QuarantineOU string
+
+	// attribute the time of the move is written to as generalized time,
+	// required with QuarantineOU or DeleteAfter. Whatever the attribute held
+	// before is overwritten, so use one reserved for this, e.g. an unused
+	// extensionAttribute, and not one admins keep notes in like info.
+	QuarantineAttribute string
+
+	// delete disabled computers in the quarantine ou that stayed there this
+	// long and have been stale for Threshold plus DeleteAfter, 0 never deletes.
+	// Computers moved there without a quarantine time get one on the next run.
+	DeleteAfter time.Duration
+
+	// only report what would be done
+	DryRun bool
+}
+
+// StaleComputer is a computer found by cleanupStaleComputers and what was done with it
+type StaleComputer struct {
+	DN                 string
+	Name               string
+	LastLogonTimestamp time.Time
+	PwdLastSet         time.Time
+	Disabled           bool
+
+	// actions that succeeded, or are planned in a dry run: disable, quarantine, delete
+	Actions []string
+	Error   error
+}
+
+// reports computers that have not logged on or changed their password within
+// the threshold and optionally disables, quarantines and finally deletes them.
+// Domain controllers, including read-only ones, are never touched. Failures of
+// single computers are recorded on the computer, not returned.
+func (s *ADComputerServiceOp) cleanupStaleComputers(opts StaleComputerOptions) ([]*StaleComputer, error) {
+	if opts.Threshold <= 0 {
+		return nil, fmt.Errorf("cleanupStaleComputers - a threshold is required")
+	}
+
+	baseDN := opts.BaseDN
+	if baseDN == "" {
+		baseDN = s.client.getDomainDN()
+	}
+
+	if opts.QuarantineAttribute == "" && (opts.QuarantineOU != "" || opts.DeleteAfter > 0) {
+		return nil, fmt.Errorf("cleanupStaleComputers - a quarantine attribute is required to quarantine or delete computers")
+	}
+
+	now := time.Now()
+	cutoff := helper.TimeToFileTime(now.Add(-opts.Threshold))
+	log.Infof("Searching computers in %s inactive since %s", baseDN, now.Add(-opts.Threshold).UTC().Format(time.RFC3339))
+
+	// lastLogonTimestamp is unset for computers that never logged on. Domain
+	// controllers are SERVER_TRUST_ACCOUNT, read-only ones PARTIAL_SECRETS_ACCOUNT,
+	// their primary groups are Domain Controllers (516) and Read-only Domain
+	// Controllers (521).
+	filter := fmt.Sprintf("(&(objectClass=computer)"+
+		"(!(userAccountControl:1.2.840.113556.1.4.803:=%d))(!(userAccountControl:1.2.840.113556.1.4.803:=%d))"+
+		"(!(primaryGroupID=516))(!(primaryGroupID=521))"+
+		"(|(!(lastLogonTimestamp=*))(lastLogonTimestamp<=%d))(pwdLastSet<=%d))",
+		int(UACServerTrustAccount), int(UACPartialSecretsAccount), cutoff, cutoff)
+
+	attributes := []string{"cn", "lastLogonTimestamp", "pwdLastSet", "userAccountControl"}
+	if opts.QuarantineAttribute != "" {
+		attributes = append(attributes, opts.QuarantineAttribute)
+	}
+	ret, err := s.client.ADObject.searchObjectPaged(filter, baseDN, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("cleanupStaleComputers - failed to search computers: %s", err)
+	}
+
+	computers := make([]*StaleComputer, 0, len(ret))
+	for _, obj := range ret {
+		computer := &StaleComputer{
+			DN:                 obj.dn,
+			Name:               obj.value("cn"),
+			LastLogonTimestamp: fileTimeValue(obj, "lastLogonTimestamp"),
+			PwdLastSet:         fileTimeValue(obj, "pwdLastSet"),
+		}
+		if uac, err := strconv.Atoi(obj.value("userAccountControl")); err == nil {
+			computer.Disabled = UserAccountControl(uac).Has(UACAccountDisable)
+		}
+		computers = append(computers, computer)
+
+		computer.Error = s.cleanupStaleComputer(computer, generalizedTimeValue(obj, opts.QuarantineAttribute), now, opts)
+		if computer.Error != nil {
+			log.Warnf("Failed to clean up %s: %s", computer.DN, computer.Error)
+		}
+	}
+
+	log.Infof("Found %d stale computers", len(computers))
+	return computers, nil
+}
+
+// applies the configured actions to a single stale computer, quarantinedAt
+// is the time written when it was moved to the quarantine ou
+func (s *ADComputerServiceOp) cleanupStaleComputer(c *StaleComputer, quarantinedAt, now time.Time, opts StaleComputerOptions) error {
+	rdn, parent, err := splitDN(c.DN)
+	if err != nil {
+		return err
+	}
+
+	quarantined := opts.QuarantineOU != "" && equalDN(parent, opts.QuarantineOU)
+
+	// the second grace period: already disabled and quarantined for long
+	// enough and inactive since
+	if quarantined && c.Disabled && opts.DeleteAfter > 0 {
+		if quarantinedAt.IsZero() {
+			log.Infof("%s has no quarantine time, starting the grace period now", c.DN)
+			if opts.DryRun {
+				return nil
+			}
+			return s.setQuarantineTime(c.DN, now, opts)
+		}
+
+		inactiveSince := c.PwdLastSet
+		if c.LastLogonTimestamp.After(inactiveSince) {
+			inactiveSince = c.LastLogonTimestamp
+		}
+
+		if now.Sub(quarantinedAt) >= opts.DeleteAfter && now.Sub(inactiveSince) >= opts.Threshold+opts.DeleteAfter {
+			if !opts.DryRun {
+				if err := s.client.ADObject.deleteObject(c.DN); err != nil {
+					return err
+				}
+			}
+			c.Actions = append(c.Actions, "delete")
+		}
+		return nil
+	}
+
+	if opts.Disable && !c.Disabled {
+		if !opts.DryRun {
+			if err := s.client.ADObject.updateAccountControl(c.DN, UACAccountDisable, 0); err != nil {
+				return err
+			}
+			c.Disabled = true
+		}
+		c.Actions = append(c.Actions, "disable")
+	}
+
+	if opts.QuarantineOU != "" && !quarantined {
+		if !opts.DryRun {
+			req := ldap.NewModifyDNRequest(c.DN, rdn, true, opts.QuarantineOU)
+			if err := s.client.client.conn.ModifyDN(req); err != nil {
+				return fmt.Errorf("failed to move %s to %s: %s", c.DN, opts.QuarantineOU, err)
+			}
+			c.DN = rdn + "," + opts.QuarantineOU
+		}
+		c.Actions = append(c.Actions, "quarantine")
+
+		if !opts.DryRun {
+			return s.setQuarantineTime(c.DN, now, opts)
+		}
+	}
+
+	return nil
+}
+
+// records when a computer was quarantined, the delete grace period counts from it
+func (s *ADComputerServiceOp) setQuarantineTime(dn string, t time.Time, opts StaleComputerOptions) error {
+	return s.client.ADObject.updateObject(dn, nil, nil, map[string][]string{
+		opts.QuarantineAttribute: {t.UTC().Format(generalizedTimeLayout)},
+	}, nil)
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputerSPNs(t *testing.T) {
+	defaults := []string{
+		"HOST/web01",
+		"HOST/web01.example.com",
+		"RestrictedKrbHost/web01",
+		"RestrictedKrbHost/web01.example.com",
+	}
+
+	tests := []struct {
+		name  string
+		extra []string
+		want  []string
+	}{
+		{
+			name: "defaults only",
+			want: defaults,
+		},
+		{
+			name:  "extra spns are appended in order",
+			extra: []string{"HTTP/web01.example.com", "HTTP/web01"},
+			want:  append(append([]string{}, defaults...), "HTTP/web01.example.com", "HTTP/web01"),
+		},
+		{
+			name:  "defaults are not repeated whatever their case",
+			extra: []string{"host/WEB01.example.com", "restrictedkrbhost/web01", "HTTP/web01"},
+			want:  append(append([]string{}, defaults...), "HTTP/web01"),
+		},
+		{
+			name:  "duplicate extra spns are kept once",
+			extra: []string{"HTTP/web01", "http/WEB01", "MSSQLSvc/web01.example.com:1433"},
+			want:  append(append([]string{}, defaults...), "HTTP/web01", "MSSQLSvc/web01.example.com:1433"),
+		},
+	}
+
+	for _, tt := range tests {
+		if got := computerSPNs("web01", "web01.example.com", tt.extra); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: computerSPNs = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
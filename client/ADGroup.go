@@ -3,28 +3,50 @@ package client
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
 	"gopkg.in/ldap.v3"
 	"strings"
+	"unicode/utf8"
 )
 
 // User is the base implementation of ad Group  object
 type ADGroup struct {
-	name        string
-	dn          string
-	description string
+	name           string
+	dn             string
+	description    string
+	samAccountName string
+	scope          GroupScope
+	category       GroupCategory
 }
 
 type ADGroupRequest struct {
 	group_name        string
 	group_base_ou     string
 	group_description string
+	group_scope       GroupScope
+	group_category    GroupCategory
+
+	// manager as dn, sid or sAMAccountName, optionally allowed to update the membership list
+	group_managed_by         string
+	group_manager_can_update bool
 }
 
 type ADGroupService interface {
 	getGroup(name, baseou string) (*ADGroup, error)
 	createGroup(createUser ADGroupRequest) error
-	deleteGroup(dn string) error
-	updateGroupName(cn, baseOU, newOU string) error
+	deleteGroup(dn string, opts ...DeleteGroupOption) error
+	moveGroup(cn, baseOU, newOU string) error
+	renameGroup(cn, baseOU string, rename GroupRename) error
+	listMembers(groupDN string) ([]string, error)
+	addMembers(groupDN string, members ...string) error
+	removeMembers(groupDN string, members ...string) error
+	setMembers(groupDN string, members []string) error
+	transitiveMembers(groupDN string) ([]string, error)
+	convertScope(groupDN string, scope GroupScope) error
+	reconcileMembers(desired map[string][]string, opts ReconcileOptions) (*ReconcileReport, error)
+	setGroupManager(groupDN, manager string, canUpdateMembership bool) error
+	clearGroupManager(groupDN string) error
+	getGroupOwners(groupDN string) (*GroupOwners, error)
 }
 
 type ADGroupServiceOp struct {
@@ -37,10 +59,10 @@ var _ ADGroupService = &ADGroupServiceOp{}
 func (s *ADGroupServiceOp) getGroup(name, baseOU string) (*ADGroup, error) {
 	log.Infof("getting group  from the ad server %s in %s", name, baseOU)
 
-	attributes := []string{"name", "cn", "sAMAccountName", "description"}
+	attributes := []string{"name", "cn", "sAMAccountName", "description", "groupType"}
 
 	// filter
-	filter := fmt.Sprintf("(&(objectclass=*)(cn=%s))", name)
+	filter := fmt.Sprintf("(&(objectclass=group)(cn=%s))", ldap.EscapeFilter(name))
 
 	// trying to get user object
 	ret, err := s.client.ADObject.searchObject(filter, baseOU, attributes)
@@ -57,17 +79,30 @@ func (s *ADGroupServiceOp) getGroup(name, baseOU string) (*ADGroup, error) {
 		return nil, fmt.Errorf("getUser - more than one user object with the same name under the same base ou found")
 	}
 
+	scope, category, err := decodeGroupType(ret[0].value("groupType"))
+	if err != nil {
+		log.Warnf("Ignoring invalid groupType on %s: %s", ret[0].dn, err)
+	}
+
 	return &ADGroup{
-		name:        ret[0].attributes["cn"][0],
-		dn:          ret[0].dn,
-		description: ret[0].attributes["sAMAccountName"][0],
+		name:           ret[0].attributes["cn"][0],
+		dn:             ret[0].dn,
+		description:    ret[0].value("description"),
+		samAccountName: ret[0].value("sAMAccountName"),
+		scope:          scope,
+		category:       category,
 	}, nil
 }
 
 // creates a new User object
 func (s *ADGroupServiceOp) createGroup(gc ADGroupRequest) error {
 
-	log.Infof("Creating group %s in %s along with the following username %s", gc.group_name, gc.group_base_ou)
+	log.Infof("Creating %s %s group %s in %s", gc.group_scope, gc.group_category, gc.group_name, gc.group_base_ou)
+
+	groupTypeValue, err := groupType(gc.group_scope, gc.group_category)
+	if err != nil {
+		return fmt.Errorf("createGroup - %s", err)
+	}
 
 	tmp, err := s.getGroup(gc.group_name, gc.group_base_ou)
 	if err != nil {
@@ -88,7 +123,15 @@ func (s *ADGroupServiceOp) createGroup(gc ADGroupRequest) error {
 	attributes["sAMAccountName"] = []string{gc.group_name}
 	attributes["name"] = []string{gc.group_name}
 	attributes["instanceType"] = []string{fmt.Sprintf("%d", 0x00000004)}
-	attributes["groupType"] = []string{fmt.Sprintf("%d", 0x80000002)}
+	attributes["groupType"] = []string{fmt.Sprintf("%d", groupTypeValue)}
+
+	var managerDN string
+	if gc.group_managed_by != "" {
+		if managerDN, err = s.resolveMember(gc.group_managed_by); err != nil {
+			return fmt.Errorf("createGroup - invalid manager: %s", err)
+		}
+		attributes["managedBy"] = []string{managerDN}
+	}
 
 	//log.Infof("the password is %s", pwdencode)
 	//return api.createObject(fmt.Sprintf("ou=%s,%s", name, baseOU), []string{"organizationalUnit", "top"}, attributes)
@@ -101,48 +144,199 @@ func (s *ADGroupServiceOp) createGroup(gc ADGroupRequest) error {
 	}
 	log.Infof("Successfully Created the Group with the cn [%s]", group_cn)
 
+	if managerDN != "" && gc.group_manager_can_update {
+		sid, err := s.objectSID(managerDN)
+		if err != nil {
+			return fmt.Errorf("createGroup - failed to grant membership access to %s: %s", managerDN, err)
+		}
+		if err := s.updateMembershipACE(fmt.Sprintf("CN=%s,%s", gc.group_name, gc.group_base_ou), &sid, nil); err != nil {
+			return fmt.Errorf("createGroup - failed to grant membership access to %s: %s", managerDN, err)
+		}
+	}
+
 	return err
 
 }
 
-// updates the name of an existing user object
-func (s *ADGroupServiceOp) updateGroupName(name, baseOU, newName string) error {
-	log.Infof("Updating name of ou %s under %s.", name, baseOU)
+// moves an existing group object to another ou
+func (s *ADGroupServiceOp) moveGroup(cn, baseOU, newOU string) error {
+	log.Infof("Moving group object %s from %s to %s.", cn, baseOU, newOU)
 
-	tmp, err := s.client.ADObject.searchObject("(objectclass=organizationalUnit)", name, nil)
+	tmp, err := s.getGroup(cn, baseOU)
 	if err != nil {
-		return fmt.Errorf("updateOUName - talking to active directory failed: %s", err)
+		return fmt.Errorf("moveGroup - talking to active directory failed: %s", err)
 	}
 
+	// a repeated move finds the group in the target ou
 	if tmp == nil {
-		return fmt.Errorf("updateOUName - ou object %s does not exists under %s: %s", name, baseOU, err)
+		if moved, err := s.getGroup(cn, newOU); err == nil && moved != nil {
+			log.Infof("Group object is already under the target ou")
+			return nil
+		}
+		return fmt.Errorf("moveGroup - group object %s does not exist under %s", cn, baseOU)
 	}
 
-	// specific uid of the ou
-	UID := fmt.Sprintf("ou=%s", newName)
+	rdn, parent, err := splitDN(tmp.dn)
+	if err != nil {
+		return fmt.Errorf("moveGroup - %s", err)
+	}
+
+	if equalDN(parent, newOU) {
+		log.Infof("Group object is already under the target ou")
+		return nil
+	}
 
-	// move ou object to new ou
-	req := ldap.NewModifyDNRequest(fmt.Sprintf("ou=%s,%s", name, baseOU), UID, true, "")
+	req := ldap.NewModifyDNRequest(tmp.dn, rdn, true, newOU)
 	if err := s.client.client.conn.ModifyDN(req); err != nil {
-		return fmt.Errorf("updateOUName - failed to move ou: %s", err)
+		return fmt.Errorf("moveGroup - failed to move group %s: %s", tmp.dn, err)
+	}
+
+	log.Infof("Group moved.")
+	return nil
+}
+
+// GroupRename describes the new names of a group, empty fields are left unchanged
+type GroupRename struct {
+	CN             string
+	SAMAccountName string
+}
+
+// renames an existing group object, updating cn and name through the rdn and
+// sAMAccountName when requested
+func (s *ADGroupServiceOp) renameGroup(cn, baseOU string, rename GroupRename) error {
+	log.Infof("Renaming group %s under %s.", cn, baseOU)
+
+	tmp, err := s.getGroup(cn, baseOU)
+	if err != nil {
+		return fmt.Errorf("renameGroup - talking to active directory failed: %s", err)
+	}
+
+	// a repeated rename finds the group under its new cn
+	if tmp == nil && rename.CN != "" {
+		if tmp, err = s.getGroup(rename.CN, baseOU); err != nil {
+			return fmt.Errorf("renameGroup - talking to active directory failed: %s", err)
+		}
+	}
+
+	if tmp == nil {
+		return fmt.Errorf("renameGroup - group object %s does not exist under %s", cn, baseOU)
+	}
+
+	if utf8.RuneCountInString(rename.CN) > maxCNLength {
+		return fmt.Errorf("renameGroup - cn %q is longer than %d characters", rename.CN, maxCNLength)
+	}
+
+	changed := make(map[string][]string)
+
+	if rename.SAMAccountName != "" && !strings.EqualFold(rename.SAMAccountName, tmp.samAccountName) {
+		if problems := samAccountNameProblems(rename.SAMAccountName); len(problems) > 0 {
+			return fmt.Errorf("renameGroup - %s", strings.Join(problems, "; "))
+		}
+
+		filter := fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(rename.SAMAccountName))
+		existing, err := s.client.ADObject.searchObject(filter, s.client.getDomainDN(), []string{"cn"})
+		if err != nil {
+			return fmt.Errorf("renameGroup - talking to active directory failed: %s", err)
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("renameGroup - sAMAccountName %s is already used by %s", rename.SAMAccountName, existing[0].dn)
+		}
+		changed["sAMAccountName"] = []string{rename.SAMAccountName}
+	}
+
+	dn := tmp.dn
+	if rename.CN != "" && rename.CN != tmp.name {
+		_, parent, err := splitDN(tmp.dn)
+		if err != nil {
+			return fmt.Errorf("renameGroup - %s", err)
+		}
+
+		rdn := "CN=" + helper.EscapeDN(rename.CN)
+		req := ldap.NewModifyDNRequest(tmp.dn, rdn, true, "")
+		if err := s.client.client.conn.ModifyDN(req); err != nil {
+			return fmt.Errorf("renameGroup - failed to rename %s: %s", tmp.dn, err)
+		}
+
+		dn = rdn + "," + parent
+		log.Infof("Group renamed to %s.", dn)
+	}
+
+	if len(changed) == 0 {
+		log.Infof("Group attributes are already up to date")
+		return nil
+	}
+
+	if err := s.client.ADObject.updateObject(dn, nil, nil, changed, nil); err != nil {
+		return fmt.Errorf("renameGroup - %s", err)
 	}
 
-	log.Infof("OU moved.")
 	return nil
 }
 
-// deletes an existing ou object.
-func (s *ADGroupServiceOp) deleteGroup(dn string) error {
-	log.Infof("Deleting user %s.", dn)
+// DeleteGroupOption adds safety checks to deleteGroup
+type DeleteGroupOption func(*deleteGroupOptions)
+
+type deleteGroupOptions struct {
+	refuseNonEmpty   bool
+	refusePrivileged bool
+}
 
-	objects, err := s.client.ADObject.searchObject("(objectclass=organizationalUnit)", dn, nil)
+// RefuseNonEmptyGroup makes deleteGroup fail for groups that still have members
+func RefuseNonEmptyGroup() DeleteGroupOption {
+	return func(o *deleteGroupOptions) {
+		o.refuseNonEmpty = true
+	}
+}
+
+// RefusePrivilegedGroup makes deleteGroup fail for groups protected by
+// AdminSDHolder (adminCount=1)
+func RefusePrivilegedGroup() DeleteGroupOption {
+	return func(o *deleteGroupOptions) {
+		o.refusePrivileged = true
+	}
+}
+
+// deletes an existing group object
+func (s *ADGroupServiceOp) deleteGroup(dn string, opts ...DeleteGroupOption) error {
+	log.Infof("Deleting group %s.", dn)
+
+	var options deleteGroupOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	group, err := s.client.ADObject.getObject(dn, []string{"objectClass", "adminCount", "objectSid"})
 	if err != nil {
-		return fmt.Errorf("deleteOU - failed remove ou %s: %s", dn, err)
+		return fmt.Errorf("deleteGroup - talking to active directory failed: %s", err)
+	}
+
+	if group == nil {
+		log.Infof("Group %s does not exist", dn)
+		return nil
+	}
+
+	if !isGroup(group) {
+		return fmt.Errorf("deleteGroup - %s is not a group", dn)
+	}
+
+	if options.refusePrivileged && group.value("adminCount") == "1" {
+		return fmt.Errorf("deleteGroup - refusing to delete privileged group %s (adminCount=1)", dn)
 	}
 
-	if len(objects) > 0 {
-		if len(objects) > 1 || !strings.EqualFold(objects[0].dn, dn) {
-			return fmt.Errorf("deleteOU - failed to delete ou %s because it has child items: %s", dn, objects[0].dn)
+	if options.refuseNonEmpty {
+		members, err := s.listMembers(dn)
+		if err != nil {
+			return fmt.Errorf("deleteGroup - %s", err)
+		}
+
+		// accounts with this primary group are not listed in member
+		primary, err := s.primaryGroupMembers([]*ADObject{group})
+		if err != nil {
+			return fmt.Errorf("deleteGroup - %s", err)
+		}
+
+		if count := len(members) + len(primary); count > 0 {
+			return fmt.Errorf("deleteGroup - refusing to delete group %s with %d members", dn, count)
 		}
 	}
 
@@ -0,0 +1,284 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+	"gopkg.in/ldap.v3"
+)
+
+// resolves a member given as dn, sid (S-1-...) or sAMAccountName to its dn
+func (s *ADGroupServiceOp) resolveMember(member string) (string, error) {
+	var filter string
+
+	switch {
+	case strings.HasPrefix(strings.ToUpper(member), "S-1-"):
+		sid, err := helper.ParseSID(member)
+		if err != nil {
+			return "", err
+		}
+		filter = fmt.Sprintf("(objectSid=%s)", helper.EscapeBinary(sid.Bytes()))
+
+	case strings.Contains(member, "="):
+		obj, err := s.client.ADObject.getObject(member, []string{"distinguishedName"})
+		if err != nil {
+			return "", err
+		}
+		if obj == nil {
+			return "", fmt.Errorf("member %s does not exist", member)
+		}
+		return obj.dn, nil
+
+	default:
+		filter = fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(member))
+	}
+
+	ret, err := s.client.ADObject.searchObject(filter, s.client.getDomainDN(), []string{"distinguishedName"})
+	if err != nil {
+		return "", err
+	}
+
+	if len(ret) == 0 {
+		return "", fmt.Errorf("member %s does not exist", member)
+	}
+
+	if len(ret) > 1 {
+		return "", fmt.Errorf("member %s is ambiguous, %d objects found", member, len(ret))
+	}
+
+	return ret[0].dn, nil
+}
+
+func (s *ADGroupServiceOp) resolveMembers(members []string) ([]string, error) {
+	dns := make([]string, 0, len(members))
+	for _, m := range members {
+		dn, err := s.resolveMember(m)
+		if err != nil {
+			return nil, err
+		}
+		dns = append(dns, dn)
+	}
+	return dns, nil
+}
+
+// returns the dns of the direct members of a group. Active directory returns
+// at most MaxValRange values per read, larger groups are read in ranges.
+func (s *ADGroupServiceOp) listMembers(groupDN string) ([]string, error) {
+	log.Infof("Listing members of %s", groupDN)
+
+	var members []string
+	attribute := "member"
+
+	for {
+		group, err := s.client.ADObject.getObject(groupDN, []string{attribute})
+		if err != nil {
+			return nil, fmt.Errorf("listMembers - failed to read members of %s: %s", groupDN, err)
+		}
+
+		if group == nil {
+			return nil, fmt.Errorf("listMembers - group %s does not exist", groupDN)
+		}
+
+		next := ""
+		for name, values := range group.attributes {
+			if strings.EqualFold(name, "member") {
+				members = append(members, values...)
+				continue
+			}
+
+			// member;range=<low>-<high>, high is * for the last range
+			lower := strings.ToLower(name)
+			if !strings.HasPrefix(lower, "member;range=") {
+				continue
+			}
+			members = append(members, values...)
+
+			bounds := strings.SplitN(strings.TrimPrefix(lower, "member;range="), "-", 2)
+			if len(bounds) == 2 && bounds[1] != "*" {
+				high, err := strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("listMembers - invalid range %s", name)
+				}
+				next = fmt.Sprintf("member;range=%d-*", high+1)
+			}
+		}
+
+		if next == "" {
+			return members, nil
+		}
+		attribute = next
+	}
+}
+
+// adds members to a group, members that already belong to it are skipped
+func (s *ADGroupServiceOp) addMembers(groupDN string, members ...string) error {
+	log.Infof("Adding %d members to %s", len(members), groupDN)
+
+	dns, err := s.resolveMembers(members)
+	if err != nil {
+		return fmt.Errorf("addMembers - %s", err)
+	}
+
+	current, err := s.listMembers(groupDN)
+	if err != nil {
+		return fmt.Errorf("addMembers - %s", err)
+	}
+
+	added := missingDNs(dns, current)
+	if len(added) == 0 {
+		log.Info("All members already belong to the group")
+		return nil
+	}
+
+	return s.modifyMembers(groupDN, added, nil)
+}
+
+// removes members from a group, members that do not belong to it are skipped
+func (s *ADGroupServiceOp) removeMembers(groupDN string, members ...string) error {
+	log.Infof("Removing %d members from %s", len(members), groupDN)
+
+	dns, err := s.resolveMembers(members)
+	if err != nil {
+		return fmt.Errorf("removeMembers - %s", err)
+	}
+
+	current, err := s.listMembers(groupDN)
+	if err != nil {
+		return fmt.Errorf("removeMembers - %s", err)
+	}
+
+	removed := commonDNs(dns, current)
+	if len(removed) == 0 {
+		log.Info("None of the members belong to the group")
+		return nil
+	}
+
+	return s.modifyMembers(groupDN, nil, removed)
+}
+
+// replaces the direct members of a group, only the difference is written
+func (s *ADGroupServiceOp) setMembers(groupDN string, members []string) error {
+	log.Infof("Setting %d members on %s", len(members), groupDN)
+
+	dns, err := s.resolveMembers(members)
+	if err != nil {
+		return fmt.Errorf("setMembers - %s", err)
+	}
+
+	current, err := s.listMembers(groupDN)
+	if err != nil {
+		return fmt.Errorf("setMembers - %s", err)
+	}
+
+	added := missingDNs(dns, current)
+	removed := missingDNs(current, dns)
+	if len(added) == 0 && len(removed) == 0 {
+		log.Info("Group members are up to date")
+		return nil
+	}
+
+	return s.modifyMembers(groupDN, added, removed)
+}
+
+// writes member changes in one modify. Members are added and deleted as
+// single values, never replaced, so a concurrent change can not be lost: it
+// makes the modify fail, and the values are then applied one at a time,
+// ignoring the ones that are already in place.
+func (s *ADGroupServiceOp) modifyMembers(groupDN string, added, removed []string) error {
+	req := ldap.NewModifyRequest(groupDN, nil)
+	if len(added) > 0 {
+		req.Add("member", added)
+	}
+	if len(removed) > 0 {
+		req.Delete("member", removed)
+	}
+
+	err := s.client.client.conn.Modify(req)
+	if err == nil {
+		log.Infof("Group %s updated, %d added, %d removed", groupDN, len(added), len(removed))
+		return nil
+	}
+
+	if !isMembershipConflict(err) {
+		return fmt.Errorf("modifyMembers - failed to update members of %s: %s", groupDN, err)
+	}
+
+	log.Info("Group membership changed concurrently, applying members one by one")
+
+	for _, dn := range added {
+		req := ldap.NewModifyRequest(groupDN, nil)
+		req.Add("member", []string{dn})
+		if err := s.client.client.conn.Modify(req); err != nil && !isExistingMember(err) {
+			return fmt.Errorf("modifyMembers - failed to add %s to %s: %s", dn, groupDN, err)
+		}
+	}
+
+	for _, dn := range removed {
+		req := ldap.NewModifyRequest(groupDN, nil)
+		req.Delete("member", []string{dn})
+		if err := s.client.client.conn.Modify(req); err != nil && !isMembershipConflict(err) {
+			return fmt.Errorf("modifyMembers - failed to remove %s from %s: %s", dn, groupDN, err)
+		}
+	}
+
+	log.Infof("Group %s updated", groupDN)
+	return nil
+}
+
+// active directory reports adding an existing member as attributeOrValueExists,
+// some versions as entryAlreadyExists
+func isExistingMember(err error) bool {
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultAttributeOrValueExists) ||
+		ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists)
+}
+
+// reports whether a member modify failed because membership changed
+// concurrently: an existing member was added, or a non-member was removed,
+// which active directory reports as noSuchAttribute or unwillingToPerform
+func isMembershipConflict(err error) bool {
+	return isExistingMember(err) ||
+		ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) ||
+		ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform)
+}
+
+// returns the normalized dns as a set
+func dnSet(dns []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(dns))
+	for _, dn := range dns {
+		set[normalizeDN(dn)] = struct{}{}
+	}
+	return set
+}
+
+// returns the dns of a that are not in b, duplicates in a are returned once
+func missingDNs(a, b []string) []string {
+	set := dnSet(b)
+
+	var out []string
+	for _, dn := range a {
+		key := normalizeDN(dn)
+		if _, ok := set[key]; !ok {
+			set[key] = struct{}{}
+			out = append(out, dn)
+		}
+	}
+	return out
+}
+
+// returns the dns of a that are also in b, duplicates in a are returned once
+func commonDNs(a, b []string) []string {
+	set := dnSet(b)
+
+	var out []string
+	for _, dn := range a {
+		key := normalizeDN(dn)
+		if _, ok := set[key]; ok {
+			delete(set, key)
+			out = append(out, dn)
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeDN(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"CN=Alice,OU=Users,DC=example,DC=com", "cn=alice,ou=users,dc=example,dc=com", true},
+		{"CN=Alice, OU=Users, DC=example, DC=com", "CN=Alice,OU=Users,DC=example,DC=com", true},
+		{`CN=Smith\, John,DC=example,DC=com`, `cn=smith\2c john,dc=example,dc=com`, true},
+		{"CN=Alice,OU=Users,DC=example,DC=com", "CN=Alice,OU=Admins,DC=example,DC=com", false},
+		{"CN=Alice,DC=example,DC=com", "CN=Alice+UID=1,DC=example,DC=com", false},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeDN(tt.a) == normalizeDN(tt.b); got != tt.equal {
+			t.Errorf("normalizeDN(%q) == normalizeDN(%q) is %t, want %t", tt.a, tt.b, got, tt.equal)
+		}
+		if got := equalDN(tt.a, tt.b); got != tt.equal {
+			t.Errorf("equalDN(%q, %q) = %t, want %t", tt.a, tt.b, got, tt.equal)
+		}
+	}
+}
+
+func TestMissingAndCommonDNs(t *testing.T) {
+	desired := []string{
+		"CN=Alice,OU=Users,DC=example,DC=com",
+		"CN=Bob,OU=Users,DC=example,DC=com",
+		"cn=bob,ou=users,dc=example,dc=com",
+		"CN=Carol,OU=Users,DC=example,DC=com",
+	}
+	current := []string{
+		"cn=alice,ou=users,dc=example,dc=com",
+		"CN=Dave,OU=Users,DC=example,DC=com",
+	}
+
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"missing desired", missingDNs(desired, current), []string{"CN=Bob,OU=Users,DC=example,DC=com", "CN=Carol,OU=Users,DC=example,DC=com"}},
+		{"missing current", missingDNs(current, desired), []string{"CN=Dave,OU=Users,DC=example,DC=com"}},
+		{"common", commonDNs(desired, current), []string{"CN=Alice,OU=Users,DC=example,DC=com"}},
+		{"common duplicates", commonDNs(desired, desired), []string{desired[0], desired[1], desired[3]}},
+		{"missing from empty", missingDNs(desired[:2], nil), desired[:2]},
+		{"common with empty", commonDNs(desired, nil), nil},
+	}
+
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
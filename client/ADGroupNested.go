@@ -0,0 +1,190 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+	"gopkg.in/ldap.v3"
+)
+
+// LDAP_MATCHING_RULE_IN_CHAIN, makes member and memberOf filters follow nested groups
+const inChainMatchingRule = "1.2.840.113556.1.4.1941"
+
+// returns the dns of all non-group objects that are members of a group, directly,
+// through nested groups or through their primary group
+func (s *ADGroupServiceOp) transitiveMembers(groupDN string) ([]string, error) {
+	log.Infof("Expanding nested members of %s", groupDN)
+
+	group, err := s.client.ADObject.getObject(groupDN, []string{"objectSid"})
+	if err != nil {
+		return nil, fmt.Errorf("transitiveMembers - talking to active directory failed: %s", err)
+	}
+
+	if group == nil {
+		return nil, fmt.Errorf("transitiveMembers - group %s does not exist", groupDN)
+	}
+
+	groups, members, err := s.chainMembers(group)
+	if err != nil {
+		log.Warnf("In-chain search failed, expanding %s recursively: %s", groupDN, err)
+
+		if groups, members, err = s.recursiveMembers(group); err != nil {
+			return nil, fmt.Errorf("transitiveMembers - %s", err)
+		}
+	}
+
+	primary, err := s.primaryGroupMembers(groups)
+	if err != nil {
+		return nil, fmt.Errorf("transitiveMembers - %s", err)
+	}
+
+	return appendUniqueDNs(members, primary...), nil
+}
+
+// expands a group with one in-chain search, returning the nested groups
+// including the group itself and the other members
+func (s *ADGroupServiceOp) chainMembers(group *ADObject) ([]*ADObject, []string, error) {
+	filter := fmt.Sprintf("(memberOf:%s:=%s)", inChainMatchingRule, ldap.EscapeFilter(group.dn))
+	ret, err := s.client.ADObject.searchObjectPaged(filter, s.client.getDomainDN(), []string{"objectClass", "objectSid"})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := []*ADObject{group}
+	var members []string
+	for _, obj := range ret {
+		if isGroup(obj) {
+			groups = append(groups, obj)
+		} else {
+			members = append(members, obj.dn)
+		}
+	}
+
+	return groups, members, nil
+}
+
+// expands a group by reading the members of every nested group, groups that
+// were already visited are skipped so membership cycles terminate
+func (s *ADGroupServiceOp) recursiveMembers(group *ADObject) ([]*ADObject, []string, error) {
+	visited := map[string]bool{strings.ToLower(group.dn): true}
+	groups := []*ADObject{group}
+	var members []string
+
+	for i := 0; i < len(groups); i++ {
+		direct, err := s.listMembers(groups[i].dn)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, dn := range direct {
+			if visited[strings.ToLower(dn)] {
+				continue
+			}
+			visited[strings.ToLower(dn)] = true
+
+			obj, err := s.client.ADObject.getObject(dn, []string{"objectClass", "objectSid"})
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// members in other domains show up as unreadable dns
+			if obj == nil {
+				members = append(members, dn)
+				continue
+			}
+
+			if isGroup(obj) {
+				groups = append(groups, obj)
+			} else {
+				members = append(members, obj.dn)
+			}
+		}
+	}
+
+	return groups, members, nil
+}
+
+// returns the objects whose primaryGroupID points at one of the groups,
+// memberOf and member never list this membership
+func (s *ADGroupServiceOp) primaryGroupMembers(groups []*ADObject) ([]string, error) {
+	var filter strings.Builder
+	for _, g := range groups {
+		v := g.value("objectSid")
+		if v == "" {
+			continue
+		}
+
+		sid, err := helper.DecodeSID([]byte(v))
+		if err != nil || len(sid.SubAuthorities) == 0 {
+			log.Warnf("Ignoring invalid objectSid on %s", g.dn)
+			continue
+		}
+		fmt.Fprintf(&filter, "(primaryGroupID=%d)", sid.RID())
+	}
+
+	if filter.Len() == 0 {
+		return nil, nil
+	}
+
+	ret, err := s.client.ADObject.searchObjectPaged(fmt.Sprintf("(|%s)", filter.String()), s.client.getDomainDN(), []string{"distinguishedName"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search primary group members: %s", err)
+	}
+
+	members := make([]string, len(ret))
+	for i, obj := range ret {
+		members[i] = obj.dn
+	}
+	return members, nil
+}
+
+// returns the dn of the primary group of an object, given its objectSid and primaryGroupID
+func (c *Client) primaryGroupDN(objectSid, primaryGroupID string) (string, error) {
+	if objectSid == "" || primaryGroupID == "" {
+		return "", nil
+	}
+
+	sid, err := helper.DecodeSID([]byte(objectSid))
+	if err != nil || len(sid.SubAuthorities) == 0 {
+		return "", fmt.Errorf("invalid objectSid")
+	}
+
+	rid, err := strconv.Atoi(primaryGroupID)
+	if err != nil {
+		return "", fmt.Errorf("invalid primaryGroupID %q", primaryGroupID)
+	}
+
+	// the primary group lives in the same domain, swap the rid
+	sub := append([]int(nil), sid.SubAuthorities...)
+	sub[len(sub)-1] = rid
+	sid.SubAuthorities = sub
+
+	filter := fmt.Sprintf("(objectSid=%s)", helper.EscapeBinary(sid.Bytes()))
+	ret, err := c.ADObject.searchObject(filter, c.getDomainDN(), []string{"distinguishedName"})
+	if err != nil {
+		return "", err
+	}
+
+	if len(ret) == 0 {
+		return "", nil
+	}
+
+	return ret[0].dn, nil
+}
+
+func isGroup(obj *ADObject) bool {
+	for _, class := range obj.values("objectClass") {
+		if strings.EqualFold(class, "group") {
+			return true
+		}
+	}
+	return false
+}
+
+// appends the dns that are not already in list
+func appendUniqueDNs(list []string, dns ...string) []string {
+	return append(list, missingDNs(dns, list)...)
+}
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+)
+
+// schemaIDGUID of the member attribute
+const memberAttributeGUID = "bf9679c0-0de6-11d0-a285-00aa003049e2"
+
+// ACL_REVISION_DS, required for dacls containing object aces
+const aclRevisionDS = 4
+
+// GroupOwners describes who manages a group
+type GroupOwners struct {
+	// dn of the managedBy object, empty when the group has no manager
+	Manager string
+
+	// whether the manager may update the membership list
+	ManagerCanUpdateMembership bool
+
+	// every sid with an explicit grant to write the member attribute
+	MembershipWriters []helper.SID
+}
+
+// sets the manager of a group, given as dn, sid or sAMAccountName. With
+// canUpdateMembership the manager is granted write access to the member
+// attribute, the grant of a previous manager is removed.
+func (s *ADGroupServiceOp) setGroupManager(groupDN, manager string, canUpdateMembership bool) error {
+	log.Infof("Setting manager of %s to %s", groupDN, manager)
+
+	managerDN, err := s.resolveMember(manager)
+	if err != nil {
+		return fmt.Errorf("setGroupManager - %s", err)
+	}
+
+	group, err := s.client.ADObject.getObject(groupDN, []string{"managedBy"})
+	if err != nil {
+		return fmt.Errorf("setGroupManager - talking to active directory failed: %s", err)
+	}
+
+	if group == nil {
+		return fmt.Errorf("setGroupManager - group %s does not exist", groupDN)
+	}
+
+	previous := group.value("managedBy")
+	if !equalDN(previous, managerDN) {
+		if err := s.client.ADObject.updateObject(group.dn, nil, nil, map[string][]string{"managedBy": {managerDN}}, nil); err != nil {
+			return fmt.Errorf("setGroupManager - %s", err)
+		}
+	}
+
+	var revoke []helper.SID
+	if previous != "" && !equalDN(previous, managerDN) {
+		if sid, err := s.objectSID(previous); err == nil {
+			revoke = append(revoke, sid)
+		} else {
+			log.Warnf("Cannot revoke membership access of previous manager %s: %s", previous, err)
+		}
+	}
+
+	sid, err := s.objectSID(managerDN)
+	if err != nil {
+		return fmt.Errorf("setGroupManager - %s", err)
+	}
+
+	var grant *helper.SID
+	if canUpdateMembership {
+		grant = &sid
+	} else {
+		revoke = append(revoke, sid)
+	}
+
+	if err := s.updateMembershipACE(group.dn, grant, revoke); err != nil {
+		return fmt.Errorf("setGroupManager - %s", err)
+	}
+
+	log.Info("Group manager updated")
+	return nil
+}
+
+// removes the manager of a group together with its grant on the member attribute
+func (s *ADGroupServiceOp) clearGroupManager(groupDN string) error {
+	log.Infof("Clearing manager of %s", groupDN)
+
+	group, err := s.client.ADObject.getObject(groupDN, []string{"managedBy"})
+	if err != nil {
+		return fmt.Errorf("clearGroupManager - talking to active directory failed: %s", err)
+	}
+
+	if group == nil {
+		return fmt.Errorf("clearGroupManager - group %s does not exist", groupDN)
+	}
+
+	previous := group.value("managedBy")
+	if previous == "" {
+		log.Info("Group has no manager")
+		return nil
+	}
+
+	if sid, err := s.objectSID(previous); err == nil {
+		if err := s.updateMembershipACE(group.dn, nil, []helper.SID{sid}); err != nil {
+			return fmt.Errorf("clearGroupManager - %s", err)
+		}
+	} else {
+		log.Warnf("Cannot revoke membership access of previous manager %s: %s", previous, err)
+	}
+
+	if err := s.client.ADObject.updateObject(group.dn, nil, nil, nil, map[string][]string{"managedBy": {}}); err != nil {
+		return fmt.Errorf("clearGroupManager - %s", err)
+	}
+
+	log.Info("Group manager cleared")
+	return nil
+}
+
+// reports the manager of a group and who may update its membership list
+func (s *ADGroupServiceOp) getGroupOwners(groupDN string) (*GroupOwners, error) {
+	group, err := s.client.ADObject.getObject(groupDN, []string{"managedBy"})
+	if err != nil {
+		return nil, fmt.Errorf("getGroupOwners - talking to active directory failed: %s", err)
+	}
+
+	if group == nil {
+		return nil, fmt.Errorf("getGroupOwners - group %s does not exist", groupDN)
+	}
+
+	sd, err := s.client.ADObject.getSecurityDescriptor(group.dn)
+	if err != nil {
+		return nil, fmt.Errorf("getGroupOwners - %s", err)
+	}
+
+	owners := &GroupOwners{Manager: group.value("managedBy")}
+	if sd.Dacl != nil {
+		for _, ace := range sd.Dacl.Aces {
+			if isMembershipACE(ace) {
+				if sid, err := helper.DecodeSID(ace.SID); err == nil {
+					owners.MembershipWriters = append(owners.MembershipWriters, sid)
+				}
+			}
+		}
+	}
+
+	if owners.Manager != "" {
+		sid, err := s.objectSID(owners.Manager)
+		if err != nil {
+			log.Warnf("Cannot read sid of manager %s: %s", owners.Manager, err)
+		}
+		for _, w := range owners.MembershipWriters {
+			if err == nil && w.String() == sid.String() {
+				owners.ManagerCanUpdateMembership = true
+			}
+		}
+	}
+
+	return owners, nil
+}
+
+// adds the member write ace for grant and removes it for the revoked sids,
+// the security descriptor is only written when something changed
+func (s *ADGroupServiceOp) updateMembershipACE(dn string, grant *helper.SID, revoke []helper.SID) error {
+	sd, err := s.client.ADObject.getSecurityDescriptor(dn)
+	if err != nil {
+		return err
+	}
+
+	if sd.Dacl == nil {
+		sd.Dacl = &helper.ACL{Revision: aclRevisionDS}
+	}
+
+	revoked := make(map[string]bool)
+	for _, sid := range revoke {
+		revoked[string(sid.Bytes())] = true
+	}
+
+	var granted bool
+	changed := false
+	aces := make([]*helper.ACE, 0, len(sd.Dacl.Aces)+1)
+	for _, ace := range sd.Dacl.Aces {
+		if isMembershipACE(ace) {
+			if revoked[string(ace.SID)] {
+				changed = true
+				continue
+			}
+			if grant != nil && string(ace.SID) == string(grant.Bytes()) {
+				granted = true
+			}
+		}
+		aces = append(aces, ace)
+	}
+
+	if grant != nil && !granted {
+		memberGUID, err := helper.ParseGUID(memberAttributeGUID)
+		if err != nil {
+			return err
+		}
+
+		ace := &helper.ACE{
+			Type:        helper.AccessAllowedObjectACEType,
+			Mask:        helper.ADSRightDSWriteProp,
+			ObjectFlags: helper.ACEObjectTypePresent,
+			ObjectType:  [16]byte(memberGUID),
+			SID:         grant.Bytes(),
+		}
+
+		// explicit aces go before the inherited ones in a canonical dacl
+		pos := len(aces)
+		for i, a := range aces {
+			if a.Flags&helper.InheritedACE != 0 {
+				pos = i
+				break
+			}
+		}
+		aces = append(aces[:pos], append([]*helper.ACE{ace}, aces[pos:]...)...)
+		changed = true
+	}
+
+	if !changed {
+		log.Info("Membership access is already up to date")
+		return nil
+	}
+
+	sd.Dacl.Aces = aces
+	if sd.Dacl.Revision < aclRevisionDS {
+		sd.Dacl.Revision = aclRevisionDS
+	}
+
+	return s.client.ADObject.setSecurityDescriptor(dn, sd)
+}
+
+// reports whether an ace is an explicit grant to write the member attribute
+func isMembershipACE(ace *helper.ACE) bool {
+	if ace.Type != helper.AccessAllowedObjectACEType || ace.Flags&helper.InheritedACE != 0 {
+		return false
+	}
+
+	if ace.ObjectFlags&helper.ACEObjectTypePresent == 0 || ace.Mask&helper.ADSRightDSWriteProp == 0 {
+		return false
+	}
+
+	return helper.GUID(ace.ObjectType).String() == memberAttributeGUID
+}
+
+// reads the objectSid of an object
+func (s *ADGroupServiceOp) objectSID(dn string) (helper.SID, error) {
+	obj, err := s.client.ADObject.getObject(dn, []string{"objectSid"})
+	if err != nil {
+		return helper.SID{}, err
+	}
+
+	if obj == nil {
+		return helper.SID{}, fmt.Errorf("object %s does not exist", dn)
+	}
+
+	return helper.DecodeSID([]byte(obj.value("objectSid")))
+}
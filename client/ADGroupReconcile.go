@@ -0,0 +1,309 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+	"gopkg.in/ldap.v3"
+)
+
+// default number of member values written per modify
+const defaultReconcileBatchSize = 500
+
+// ReconcileOptions controls a membership reconciliation run
+type ReconcileOptions struct {
+	// upper bound for adds plus removes over all groups, 0 means no limit.
+	// When the plan exceeds it nothing is written.
+	MaxChanges int
+
+	// member values per modify, defaults to 500
+	BatchSize int
+
+	// only compute the plan
+	DryRun bool
+}
+
+// GroupReconcileResult is the outcome for a single group
+type GroupReconcileResult struct {
+	DN      string
+	Added   []string
+	Removed []string
+
+	// desired members that could not be resolved, the group is left unchanged
+	Unresolved []string
+
+	Applied bool
+	Error   error
+}
+
+// ReconcileReport is the outcome of a reconciliation run
+type ReconcileReport struct {
+	Groups  []*GroupReconcileResult
+	Adds    int
+	Removes int
+
+	// set when the plan exceeded MaxChanges and nothing was written
+	Aborted bool
+}
+
+// Failed returns the groups that could not be reconciled
+func (r *ReconcileReport) Failed() []*GroupReconcileResult {
+	var failed []*GroupReconcileResult
+	for _, g := range r.Groups {
+		if g.Error != nil {
+			failed = append(failed, g)
+		}
+	}
+	return failed
+}
+
+// MaxChangesError is returned when a reconciliation plan exceeds ReconcileOptions.MaxChanges
+type MaxChangesError struct {
+	Changes    int
+	MaxChanges int
+}
+
+func (e *MaxChangesError) Error() string {
+	return fmt.Sprintf("reconciliation needs %d changes, more than the allowed %d", e.Changes, e.MaxChanges)
+}
+
+// brings the direct members of one or more groups to the desired state.
+// desired maps group dns to members given as dn, sid or sAMAccountName. The
+// whole plan is computed before anything is written, so the change limit
+// covers all groups. Failures of single groups are reported, not returned.
+func (s *ADGroupServiceOp) reconcileMembers(desired map[string][]string, opts ReconcileOptions) (*ReconcileReport, error) {
+	log.Infof("Reconciling members of %d groups", len(desired))
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultReconcileBatchSize
+	}
+
+	groups := make([]string, 0, len(desired))
+	for dn := range desired {
+		groups = append(groups, dn)
+	}
+	sort.Strings(groups)
+
+	report := &ReconcileReport{}
+	for _, dn := range groups {
+		result := s.planMembers(dn, desired[dn])
+		report.Groups = append(report.Groups, result)
+
+		if result.Error == nil {
+			report.Adds += len(result.Added)
+			report.Removes += len(result.Removed)
+		}
+	}
+
+	log.Infof("Reconciliation plan: %d adds, %d removes", report.Adds, report.Removes)
+
+	if changes := report.Adds + report.Removes; opts.MaxChanges > 0 && changes > opts.MaxChanges {
+		report.Aborted = true
+		return report, fmt.Errorf("reconcileMembers - %s", &MaxChangesError{Changes: changes, MaxChanges: opts.MaxChanges})
+	}
+
+	if opts.DryRun {
+		log.Info("Dry run, no changes written")
+		return report, nil
+	}
+
+	for _, result := range report.Groups {
+		if result.Error != nil || len(result.Added)+len(result.Removed) == 0 {
+			continue
+		}
+
+		if err := s.applyMembers(result, opts.BatchSize); err != nil {
+			result.Error = err
+			log.Warnf("Failed to reconcile %s: %s", result.DN, err)
+			continue
+		}
+		result.Applied = true
+	}
+
+	return report, nil
+}
+
+// computes the member changes of a single group
+func (s *ADGroupServiceOp) planMembers(groupDN string, members []string) *GroupReconcileResult {
+	result := &GroupReconcileResult{DN: groupDN}
+
+	dns, unresolved, err := s.resolveMemberDNs(members)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	if len(unresolved) > 0 {
+		log.Warnf("Cannot resolve %d members of %s: %v", len(unresolved), groupDN, unresolved)
+		result.Unresolved = unresolved
+		result.Error = fmt.Errorf("%d desired members could not be resolved", len(unresolved))
+		return result
+	}
+
+	current, err := s.listMembers(groupDN)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	result.Added = missingDNs(dns, current)
+	result.Removed = missingDNs(current, dns)
+	return result
+}
+
+// maximum number of terms in the or-filter of a bulk member lookup
+const resolveBatchSize = 100
+
+// resolves members given as dn, sid (S-1-...) or sAMAccountName in the domain
+// with one search per batch instead of one per member. The dns are returned
+// in input order without duplicates, together with the members that do not
+// exist or are ambiguous.
+func (s *ADGroupServiceOp) resolveMemberDNs(members []string) ([]string, []string, error) {
+	keys := make([]string, len(members))
+	var terms []string
+	seen := make(map[string]bool)
+
+	for i, m := range members {
+		key, term, err := memberLookup(m)
+		if err != nil {
+			continue
+		}
+
+		keys[i] = key
+		if !seen[key] {
+			seen[key] = true
+			terms = append(terms, term)
+		}
+	}
+
+	index := newMemberIndex()
+	for len(terms) > 0 {
+		var batch []string
+		batch, terms = splitBatch(terms, resolveBatchSize)
+
+		filter := "(|" + strings.Join(batch, "") + ")"
+		ret, err := s.client.ADObject.searchObjectPaged(filter, s.client.getDomainDN(), []string{"distinguishedName", "objectSid", "sAMAccountName"})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve members: %s", err)
+		}
+
+		for _, obj := range ret {
+			index.add(obj)
+		}
+	}
+
+	dns, unresolved := index.resolve(members, keys)
+	return dns, unresolved, nil
+}
+
+// returns the index key of a member given as dn, sid or sAMAccountName and
+// the filter term that finds it
+func memberLookup(member string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(strings.ToUpper(member), "S-1-"):
+		sid, err := helper.ParseSID(member)
+		if err != nil {
+			return "", "", err
+		}
+		return "sid:" + sid.String(), fmt.Sprintf("(objectSid=%s)", helper.EscapeBinary(sid.Bytes())), nil
+
+	case strings.Contains(member, "="):
+		return "dn:" + normalizeDN(member), fmt.Sprintf("(distinguishedName=%s)", ldap.EscapeFilter(member)), nil
+	}
+
+	return "sam:" + strings.ToLower(member), fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(member)), nil
+}
+
+// memberIndex holds the objects found for a bulk member lookup under all of
+// their keys, a key matching different objects is ambiguous
+type memberIndex struct {
+	found     map[string]string
+	ambiguous map[string]bool
+}
+
+func newMemberIndex() *memberIndex {
+	return &memberIndex{found: make(map[string]string), ambiguous: make(map[string]bool)}
+}
+
+func (x *memberIndex) add(obj *ADObject) {
+	x.index("dn:"+normalizeDN(obj.dn), obj.dn)
+	if name := obj.value("sAMAccountName"); name != "" {
+		x.index("sam:"+strings.ToLower(name), obj.dn)
+	}
+	if sid, err := helper.DecodeSID([]byte(obj.value("objectSid"))); err == nil {
+		x.index("sid:"+sid.String(), obj.dn)
+	}
+}
+
+func (x *memberIndex) index(key, dn string) {
+	if prev, ok := x.found[key]; ok {
+		if normalizeDN(prev) != normalizeDN(dn) {
+			x.ambiguous[key] = true
+		}
+		return
+	}
+	x.found[key] = dn
+}
+
+// maps members to dns by their keys, an empty key never resolves. The dns
+// are returned in input order without duplicates.
+func (x *memberIndex) resolve(members, keys []string) ([]string, []string) {
+	var dns, unresolved []string
+	for i, m := range members {
+		dn, ok := x.found[keys[i]]
+		if keys[i] == "" || !ok || x.ambiguous[keys[i]] {
+			unresolved = append(unresolved, m)
+			continue
+		}
+		dns = append(dns, dn)
+	}
+
+	return missingDNs(dns, nil), unresolved
+}
+
+// memberBatch is a single modify of a reconciliation
+type memberBatch struct {
+	added, removed []string
+}
+
+// writes the planned changes of a group in batches of at most size values
+func (s *ADGroupServiceOp) applyMembers(result *GroupReconcileResult, size int) error {
+	for _, batch := range memberBatches(result.Added, result.Removed, size) {
+		if err := s.modifyMembers(result.DN, batch.added, batch.removed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splits adds and removes into batches of at most size values, removes fill
+// up the room the adds leave. A size of 0 puts everything into one batch.
+func memberBatches(added, removed []string, size int) []memberBatch {
+	if size <= 0 {
+		size = len(added) + len(removed)
+	}
+
+	var batches []memberBatch
+	for len(added) > 0 || len(removed) > 0 {
+		var b memberBatch
+		b.added, added = splitBatch(added, size)
+		b.removed, removed = splitBatch(removed, size-len(b.added))
+		batches = append(batches, b)
+	}
+
+	return batches
+}
+
+func splitBatch(values []string, size int) ([]string, []string) {
+	if size <= 0 {
+		return nil, values
+	}
+	if len(values) <= size {
+		return values, nil
+	}
+	return values[:size], values[size:]
+}
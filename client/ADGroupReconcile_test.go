@@ -0,0 +1,167 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/surajsub/winad-client-go/helper"
+)
+
+func TestSplitBatch(t *testing.T) {
+	values := []string{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		size        int
+		batch, rest []string
+	}{
+		{2, []string{"a", "b"}, []string{"c", "d", "e"}},
+		{5, values, nil},
+		{10, values, nil},
+		{0, nil, values},
+		{-1, nil, values},
+	}
+
+	for _, tt := range tests {
+		batch, rest := splitBatch(values, tt.size)
+		if !reflect.DeepEqual(batch, tt.batch) || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("splitBatch(%d) = %v, %v, want %v, %v", tt.size, batch, rest, tt.batch, tt.rest)
+		}
+	}
+
+	if batch, rest := splitBatch(nil, 3); batch != nil || rest != nil {
+		t.Errorf("splitBatch(nil) = %v, %v", batch, rest)
+	}
+}
+
+func TestMemberBatches(t *testing.T) {
+	tests := []struct {
+		name           string
+		added, removed []string
+		size           int
+		want           []memberBatch
+	}{
+		{
+			name: "nothing to do",
+			size: 3,
+		},
+		{
+			name:    "fits into one batch",
+			added:   []string{"a1"},
+			removed: []string{"r1", "r2"},
+			size:    3,
+			want:    []memberBatch{{added: []string{"a1"}, removed: []string{"r1", "r2"}}},
+		},
+		{
+			name:    "removes fill up the room left by adds",
+			added:   []string{"a1", "a2", "a3", "a4"},
+			removed: []string{"r1", "r2", "r3"},
+			size:    3,
+			want: []memberBatch{
+				{added: []string{"a1", "a2", "a3"}},
+				{added: []string{"a4"}, removed: []string{"r1", "r2"}},
+				{removed: []string{"r3"}},
+			},
+		},
+		{
+			name:    "only removes",
+			removed: []string{"r1", "r2", "r3"},
+			size:    2,
+			want: []memberBatch{
+				{removed: []string{"r1", "r2"}},
+				{removed: []string{"r3"}},
+			},
+		},
+		{
+			name:    "no size limit",
+			added:   []string{"a1", "a2"},
+			removed: []string{"r1"},
+			want:    []memberBatch{{added: []string{"a1", "a2"}, removed: []string{"r1"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		got := memberBatches(tt.added, tt.removed, tt.size)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: memberBatches = %+v, want %+v", tt.name, got, tt.want)
+		}
+		for _, b := range got {
+			if tt.size > 0 && len(b.added)+len(b.removed) > tt.size {
+				t.Errorf("%s: batch %+v has more than %d values", tt.name, b, tt.size)
+			}
+		}
+	}
+}
+
+func TestMemberLookup(t *testing.T) {
+	tests := []struct {
+		member string
+		key    string
+		term   string
+	}{
+		{"jdoe", "sam:jdoe", "(sAMAccountName=jdoe)"},
+		{"JDoe", "sam:jdoe", "(sAMAccountName=JDoe)"},
+		{"a*b", "sam:a*b", `(sAMAccountName=a\2ab)`},
+		{"CN=John Doe,OU=Users,DC=example,DC=com", "dn:cn=john doe,ou=users,dc=example,dc=com", "(distinguishedName=CN=John Doe,OU=Users,DC=example,DC=com)"},
+		{"s-1-5-32-544", "sid:S-1-5-32-544", `(objectSid=\01\02\00\00\00\00\00\05\20\00\00\00\20\02\00\00)`},
+	}
+
+	for _, tt := range tests {
+		key, term, err := memberLookup(tt.member)
+		if err != nil || key != tt.key || term != tt.term {
+			t.Errorf("memberLookup(%q) = %q, %q, %v, want %q, %q", tt.member, key, term, err, tt.key, tt.term)
+		}
+	}
+
+	if _, _, err := memberLookup("S-1-x"); err == nil {
+		t.Error("memberLookup with an invalid sid should fail")
+	}
+}
+
+func TestMemberIndexResolve(t *testing.T) {
+	sid, err := helper.ParseSID("S-1-5-21-1-2-3-1105")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := newMemberIndex()
+	for _, obj := range []*ADObject{
+		{dn: "CN=Alice,OU=Users,DC=example,DC=com", attributes: map[string][]string{
+			"sAMAccountName": {"alice"},
+			"objectSid":      {string(sid.Bytes())},
+		}},
+		// found again by another batch, with different dn case
+		{dn: "cn=alice,ou=users,dc=example,dc=com", attributes: map[string][]string{
+			"sAMAccountName": {"alice"},
+		}},
+		{dn: "CN=Svc,OU=Users,DC=example,DC=com", attributes: map[string][]string{"sAMAccountName": {"svc"}}},
+		{dn: "CN=Svc,OU=Legacy,DC=example,DC=com", attributes: map[string][]string{"sAMAccountName": {"SVC"}}},
+	} {
+		index.add(obj)
+	}
+
+	members := []string{
+		"alice",
+		"S-1-5-21-1-2-3-1105",
+		"CN=ALICE,OU=Users,DC=example,DC=com",
+		"svc",
+		"CN=Svc,OU=Legacy,DC=example,DC=com",
+		"bob",
+		"S-1-x",
+	}
+	keys := make([]string, len(members))
+	for i, m := range members {
+		keys[i], _, _ = memberLookup(m)
+	}
+
+	dns, unresolved := index.resolve(members, keys)
+
+	wantDNs := []string{"CN=Alice,OU=Users,DC=example,DC=com", "CN=Svc,OU=Legacy,DC=example,DC=com"}
+	if !reflect.DeepEqual(dns, wantDNs) {
+		t.Errorf("resolved dns = %v, want %v", dns, wantDNs)
+	}
+
+	wantUnresolved := []string{"svc", "bob", "S-1-x"}
+	if !reflect.DeepEqual(unresolved, wantUnresolved) {
+		t.Errorf("unresolved = %v, want %v", unresolved, wantUnresolved)
+	}
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/ldap.v3"
+)
+
+// GroupScope is the scope part of groupType, the zero value is Global
+type GroupScope int
+
+// group scopes
+const (
+	GroupScopeGlobal GroupScope = iota
+	GroupScopeDomainLocal
+	GroupScopeUniversal
+)
+
+// GroupCategory is the category part of groupType, the zero value is Security
+type GroupCategory int
+
+// group categories
+const (
+	GroupCategorySecurity GroupCategory = iota
+	GroupCategoryDistribution
+)
+
+// groupType bits
+const (
+	groupTypeGlobal      = 0x00000002
+	groupTypeDomainLocal = 0x00000004
+	groupTypeUniversal   = 0x00000008
+	groupTypeSecurity    = -0x80000000
+
+	groupTypeScopeMask = groupTypeGlobal | groupTypeDomainLocal | groupTypeUniversal
+)
+
+func (s GroupScope) String() string {
+	switch s {
+	case GroupScopeGlobal:
+		return "Global"
+	case GroupScopeDomainLocal:
+		return "DomainLocal"
+	case GroupScopeUniversal:
+		return "Universal"
+	}
+	return fmt.Sprintf("GroupScope(%d)", int(s))
+}
+
+func (c GroupCategory) String() string {
+	switch c {
+	case GroupCategorySecurity:
+		return "Security"
+	case GroupCategoryDistribution:
+		return "Distribution"
+	}
+	return fmt.Sprintf("GroupCategory(%d)", int(c))
+}
+
+func (s GroupScope) bit() (int32, error) {
+	switch s {
+	case GroupScopeGlobal:
+		return groupTypeGlobal, nil
+	case GroupScopeDomainLocal:
+		return groupTypeDomainLocal, nil
+	case GroupScopeUniversal:
+		return groupTypeUniversal, nil
+	}
+	return 0, fmt.Errorf("unknown group scope %d", int(s))
+}
+
+// returns the groupType value for a scope and category. Active directory
+// stores groupType as a signed 32 bit integer, so security groups are negative.
+func groupType(scope GroupScope, category GroupCategory) (int32, error) {
+	v, err := scope.bit()
+	if err != nil {
+		return 0, err
+	}
+
+	switch category {
+	case GroupCategorySecurity:
+		v |= groupTypeSecurity
+	case GroupCategoryDistribution:
+	default:
+		return 0, fmt.Errorf("unknown group category %d", int(category))
+	}
+
+	return v, nil
+}
+
+// splits a groupType value into scope and category
+func decodeGroupType(value string) (GroupScope, GroupCategory, error) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid groupType %q", value)
+	}
+
+	category := GroupCategoryDistribution
+	if int32(v)&groupTypeSecurity != 0 {
+		category = GroupCategorySecurity
+	}
+
+	switch int32(v) & groupTypeScopeMask {
+	case groupTypeGlobal:
+		return GroupScopeGlobal, category, nil
+	case groupTypeDomainLocal:
+		return GroupScopeDomainLocal, category, nil
+	case groupTypeUniversal:
+		return GroupScopeUniversal, category, nil
+	}
+
+	return 0, 0, fmt.Errorf("groupType %q has no scope", value)
+}
+
+// ScopeConversionError lists the memberships that prevent a scope conversion
+type ScopeConversionError struct {
+	DN       string
+	From, To GroupScope
+	Problems []string
+}
+
+func (e *ScopeConversionError) Error() string {
+	return fmt.Sprintf("cannot convert %s from %s to %s: %s", e.DN, e.From, e.To, strings.Join(e.Problems, "; "))
+}
+
+// changes the scope of a group. Active directory only converts between
+// universal and the other scopes, so global and domain local groups are
+// converted through universal. All steps are validated before anything is
+// written.
+func (s *ADGroupServiceOp) convertScope(groupDN string, scope GroupScope) error {
+	log.Infof("Converting scope of %s to %s", groupDN, scope)
+
+	if _, err := scope.bit(); err != nil {
+		return fmt.Errorf("convertScope - %s", err)
+	}
+
+	group, err := s.client.ADObject.getObject(groupDN, []string{"groupType"})
+	if err != nil {
+		return fmt.Errorf("convertScope - talking to active directory failed: %s", err)
+	}
+
+	if group == nil {
+		return fmt.Errorf("convertScope - group %s does not exist", groupDN)
+	}
+
+	current, _, err := decodeGroupType(group.value("groupType"))
+	if err != nil {
+		return fmt.Errorf("convertScope - %s", err)
+	}
+
+	if current == scope {
+		log.Info("Group already has this scope")
+		return nil
+	}
+
+	steps := []GroupScope{scope}
+	if current != GroupScopeUniversal && scope != GroupScopeUniversal {
+		steps = []GroupScope{GroupScopeUniversal, scope}
+	}
+
+	from := current
+	for _, to := range steps {
+		if err := s.validateScopeChange(group.dn, from, to); err != nil {
+			return fmt.Errorf("convertScope - %s", err)
+		}
+		from = to
+	}
+
+	for _, to := range steps {
+		// fetch the current value so bits other than the scope are kept
+		obj, err := s.client.ADObject.getObject(group.dn, []string{"groupType"})
+		if err != nil {
+			return fmt.Errorf("convertScope - talking to active directory failed: %s", err)
+		}
+
+		v, err := strconv.ParseInt(obj.value("groupType"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("convertScope - invalid groupType on %s", group.dn)
+		}
+
+		bit, _ := to.bit()
+		value := int32(v)&^groupTypeScopeMask | bit
+
+		if err := s.client.ADObject.updateObject(group.dn, nil, nil, map[string][]string{
+			"groupType": {strconv.Itoa(int(value))},
+		}, nil); err != nil {
+			return fmt.Errorf("convertScope - failed to convert %s to %s: %s", group.dn, to, err)
+		}
+		log.Infof("Group %s converted to %s", group.dn, to)
+	}
+
+	return nil
+}
+
+// checks the membership rules active directory enforces for a single
+// conversion step
+func (s *ADGroupServiceOp) validateScopeChange(dn string, from, to GroupScope) error {
+	var problems []string
+
+	switch {
+	case from == GroupScopeGlobal && to == GroupScopeUniversal:
+		// universal groups can not be members of global groups
+		parents, err := s.relatedGroups(fmt.Sprintf("(member=%s)", ldap.EscapeFilter(dn)), GroupScopeGlobal)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			problems = append(problems, fmt.Sprintf("member of global group %s", p))
+		}
+
+	case from == GroupScopeDomainLocal && to == GroupScopeUniversal:
+		// domain local groups can not be members of universal groups
+		children, err := s.relatedGroups(fmt.Sprintf("(memberOf=%s)", ldap.EscapeFilter(dn)), GroupScopeDomainLocal)
+		if err != nil {
+			return err
+		}
+		for _, c := range children {
+			problems = append(problems, fmt.Sprintf("has domain local member %s", c))
+		}
+
+	case from == GroupScopeUniversal && to == GroupScopeDomainLocal:
+		// domain local groups can not be members of universal groups
+		parents, err := s.relatedGroups(fmt.Sprintf("(member=%s)", ldap.EscapeFilter(dn)), GroupScopeUniversal)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			problems = append(problems, fmt.Sprintf("member of universal group %s", p))
+		}
+
+	case from == GroupScopeUniversal && to == GroupScopeGlobal:
+		// global groups only contain global groups and accounts of their own domain
+		children, err := s.relatedGroups(fmt.Sprintf("(memberOf=%s)", ldap.EscapeFilter(dn)), GroupScopeUniversal)
+		if err != nil {
+			return err
+		}
+		for _, c := range children {
+			problems = append(problems, fmt.Sprintf("has universal member %s", c))
+		}
+
+		members, err := s.listMembers(dn)
+		if err != nil {
+			return err
+		}
+		domain := s.client.getDomainDN()
+		foreign := "cn=foreignsecurityprincipals," + domain
+		for _, m := range members {
+			lower := strings.ToLower(m)
+			if !strings.HasSuffix(lower, ","+domain) || strings.HasSuffix(lower, ","+foreign) {
+				problems = append(problems, fmt.Sprintf("has member %s from another domain", m))
+			}
+		}
+
+	default:
+		return fmt.Errorf("conversion from %s to %s is not supported", from, to)
+	}
+
+	if len(problems) > 0 {
+		return &ScopeConversionError{DN: dn, From: from, To: to, Problems: problems}
+	}
+
+	return nil
+}
+
+// returns the dns of the groups with the given scope matching filter
+func (s *ADGroupServiceOp) relatedGroups(filter string, scope GroupScope) ([]string, error) {
+	ret, err := s.client.ADObject.searchObject(fmt.Sprintf("(&(objectClass=group)%s)", filter), s.client.getDomainDN(), []string{"groupType"})
+	if err != nil {
+		return nil, err
+	}
+
+	var dns []string
+	for _, obj := range ret {
+		if sc, _, err := decodeGroupType(obj.value("groupType")); err == nil && sc == scope {
+			dns = append(dns, obj.dn)
+		}
+	}
+	return dns, nil
+}
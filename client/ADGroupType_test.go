@@ -0,0 +1,62 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestGroupType(t *testing.T) {
+	tests := []struct {
+		value    int32
+		scope    GroupScope
+		category GroupCategory
+	}{
+		{-2147483646, GroupScopeGlobal, GroupCategorySecurity},
+		{-2147483644, GroupScopeDomainLocal, GroupCategorySecurity},
+		{-2147483640, GroupScopeUniversal, GroupCategorySecurity},
+		{2, GroupScopeGlobal, GroupCategoryDistribution},
+		{4, GroupScopeDomainLocal, GroupCategoryDistribution},
+		{8, GroupScopeUniversal, GroupCategoryDistribution},
+	}
+
+	for _, tt := range tests {
+		got, err := groupType(tt.scope, tt.category)
+		if err != nil || got != tt.value {
+			t.Errorf("groupType(%s, %s) = %d, %v, want %d", tt.scope, tt.category, got, err, tt.value)
+		}
+
+		scope, category, err := decodeGroupType(strconv.Itoa(int(tt.value)))
+		if err != nil || scope != tt.scope || category != tt.category {
+			t.Errorf("decodeGroupType(%d) = %s, %s, %v, want %s, %s", tt.value, scope, category, err, tt.scope, tt.category)
+		}
+	}
+}
+
+func TestDecodeGroupTypeExtraBits(t *testing.T) {
+	// 0x1 marks groups created by the system, -2147483643 is BUILTIN\Administrators
+	scope, category, err := decodeGroupType("-2147483643")
+	if err != nil || scope != GroupScopeDomainLocal || category != GroupCategorySecurity {
+		t.Errorf("decodeGroupType(-2147483643) = %s, %s, %v", scope, category, err)
+	}
+
+	// unsigned representation of a security global group
+	scope, category, err = decodeGroupType("2147483650")
+	if err != nil || scope != GroupScopeGlobal || category != GroupCategorySecurity {
+		t.Errorf("decodeGroupType(2147483650) = %s, %s, %v", scope, category, err)
+	}
+}
+
+func TestGroupTypeInvalid(t *testing.T) {
+	if _, err := groupType(GroupScope(7), GroupCategorySecurity); err == nil {
+		t.Error("groupType with an unknown scope should fail")
+	}
+	if _, err := groupType(GroupScopeGlobal, GroupCategory(7)); err == nil {
+		t.Error("groupType with an unknown category should fail")
+	}
+
+	for _, value := range []string{"", "global", "-2147483648", "0", "16"} {
+		if _, _, err := decodeGroupType(value); err == nil {
+			t.Errorf("decodeGroupType(%q) should fail", value)
+		}
+	}
+}
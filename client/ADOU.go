@@ -17,7 +17,7 @@ type ADOU struct {
 
 type ADOUService interface {
 	getOU(name, baseou string) (*ADOU, error)
-	createOU(name, baseOU, description string) error
+	createOU(name, baseOU, description string, opts ...CreateOption) error
 	deleteOU(dn string) error
 	updateOUDescription(cn, baseOU, newOU string) error
 }
@@ -59,7 +59,7 @@ func (s *ADOUServiceOp) getOU(name, baseOU string) (*ADOU, error) {
 }
 
 // creates a new ou object
-func (s *ADOUServiceOp) createOU(name, baseOU, description string) error {
+func (s *ADOUServiceOp) createOU(name, baseOU, description string, opts ...CreateOption) error {
 	log.Infof("Creating ou %s in %s", name, baseOU)
 
 	tmp, err := s.getOU(name, baseOU)
@@ -71,7 +71,19 @@ func (s *ADOUServiceOp) createOU(name, baseOU, description string) error {
 	if tmp != nil {
 		if tmp.name == name && tmp.dn == fmt.Sprintf("ou=%s,%s", name, baseOU) {
 			log.Infof("OU object %s already exists, updating description", name)
-			return s.updateOUDescription(name, baseOU, description)
+			if err := s.updateOUDescription(name, baseOU, description); err != nil {
+				return err
+			}
+
+			options := &createOptions{}
+			for _, opt := range opts {
+				opt(options)
+			}
+
+			if options.protect {
+				return s.client.ADObject.setProtection(tmp.dn, true)
+			}
+			return nil
 		}
 
 		return fmt.Errorf("createOU - ou object %s already exists under this base ou %s", name, baseOU)
@@ -81,7 +93,7 @@ func (s *ADOUServiceOp) createOU(name, baseOU, description string) error {
 	attributes["ou"] = []string{name}
 	attributes["description"] = []string{description}
 
-	return s.client.ADObject.createObject(fmt.Sprintf("ou=%s,%s", name, baseOU), []string{"organizationalUnit", "top"}, attributes)
+	return s.client.ADObject.createObject(fmt.Sprintf("ou=%s,%s", name, baseOU), []string{"organizationalUnit", "top"}, attributes, opts...)
 }
 
 // moves an existing ou object to a new ou
@@ -172,5 +184,19 @@ func (s *ADOUServiceOp) deleteOU(dn string) error {
 		}
 	}
 
+	if len(objects) == 0 {
+		log.Info("OU is already deleted")
+		return nil
+	}
+
+	protected, err := s.client.ADObject.getProtection(dn)
+	if err != nil {
+		return fmt.Errorf("deleteOU - failed to read protection of ou %s: %s", dn, err)
+	}
+
+	if protected {
+		return &ProtectedObjectError{DN: dn}
+	}
+
 	return s.client.ADObject.deleteObject(dn)
 }
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"github.com/surajsub/winad-client-go/helper"
 	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/ldap.v3"
@@ -17,19 +19,60 @@ type ADObject struct {
 
 type ADObjectService interface {
 	searchObject(filter, baseDN string, attributes []string) ([]*ADObject, error)
+	searchObjectPaged(filter, baseDN string, attributes []string) ([]*ADObject, error)
+	getObject(dn string, attributes []string) (*ADObject, error)
 	deleteObject(dn string) error
-	createObject(dn string, classes []string, attributes map[string][]string) error
-	updateObject(dn string, classes []string, added, changed, removed map[string][]string) error
+	createObject(dn string, classes []string, attributes map[string][]string, opts ...CreateOption) error
+	updateObject(dn string, classes []string, added, changed, removed map[string][]string, controls ...ldap.Control) error
+	updateAttributes(dn string, desired map[string][]string) error
+	updateAttributesIfUnchanged(read *ADObject, desired map[string][]string) error
+	update(dn string, desired interface{}) error
+	getProtection(dn string) (bool, error)
+	setProtection(dn string, protect bool) error
+	getSecurityDescriptor(dn string) (*helper.SecurityDescriptor, error)
+	setSecurityDescriptor(dn string, sd *helper.SecurityDescriptor) error
+	updateAccountControl(dn string, set, clear UserAccountControl) error
+}
+
+// CreateOption changes how createObject creates an object
+type CreateOption func(*createOptions)
+
+type createOptions struct {
+	protect bool
+}
+
+// ProtectFromDeletion marks the new object as protected from accidental deletion
+func ProtectFromDeletion() CreateOption {
+	return func(o *createOptions) {
+		o.protect = true
+	}
 }
 
 type ADObjectServiceOp struct {
 	client *Client
+
+	// whether the server supports the assertion control, read once
+	assertionOnce      sync.Once
+	assertionSupported bool
 }
 
 var _ ADObjectService = &ADObjectServiceOp{}
 
 // Search returns all ad objects which match the filter
 func (s *ADObjectServiceOp) searchObject(filter, baseDN string, attributes []string) ([]*ADObject, error) {
+	return s.search(filter, baseDN, ldap.ScopeWholeSubtree, attributes, nil, 0)
+}
+
+// searchObjectPaged is searchObject for searches that may return more objects
+// than the server returns at once (MaxPageSize, 1000 by default), the results
+// are read in pages
+func (s *ADObjectServiceOp) searchObjectPaged(filter, baseDN string, attributes []string) ([]*ADObject, error) {
+	return s.search(filter, baseDN, ldap.ScopeWholeSubtree, attributes, nil, searchPageSize)
+}
+
+// search runs a search with an explicit scope and request controls, a non zero
+// pageSize reads the results in pages of that size
+func (s *ADObjectServiceOp) search(filter, baseDN string, scope int, attributes []string, controls []ldap.Control, pageSize uint32) ([]*ADObject, error) {
 	log.Infof("Searching for objects in %s with filter %s", baseDN, filter)
 
 	if len(attributes) == 0 {
@@ -38,17 +81,23 @@ func (s *ADObjectServiceOp) searchObject(filter, baseDN string, attributes []str
 
 	request := ldap.NewSearchRequest(
 		baseDN,
-		ldap.ScopeWholeSubtree,
+		scope,
 		ldap.NeverDerefAliases,
 		0,
 		0,
 		false,
 		filter,
 		attributes,
-		nil,
+		controls,
 	)
 
-	result, err := s.client.client.conn.Search(request)
+	var result *ldap.SearchResult
+	var err error
+	if pageSize > 0 {
+		result, err = s.client.client.conn.SearchWithPaging(request, pageSize)
+	} else {
+		result, err = s.client.client.conn.Search(request)
+	}
 	if err != nil {
 		if err, ok := err.(*ldap.Error); ok {
 			if err.ResultCode == 32 {
@@ -81,7 +130,7 @@ func (s *ADObjectServiceOp) searchObject(filter, baseDN string, attributes []str
 func (s *ADObjectServiceOp) getObject(dn string, attributes []string) (*ADObject, error) {
 	log.Infof("Trying to get object %s", dn)
 
-	objects, err := s.searchObject("(objectclass=*)", dn, attributes)
+	objects, err := s.search("(objectclass=*)", dn, ldap.ScopeBaseObject, attributes, nil, 0)
 	if err != nil {
 		return nil, fmt.Errorf("getObject - failed to get object %s: %s", dn, err)
 	}
@@ -98,9 +147,14 @@ func (s *ADObjectServiceOp) getObject(dn string, attributes []string) (*ADObject
 }
 
 // Create create a ad object
-func (s *ADObjectServiceOp) createObject(dn string, classes []string, attributes map[string][]string) error {
+func (s *ADObjectServiceOp) createObject(dn string, classes []string, attributes map[string][]string, opts ...CreateOption) error {
 	log.Infof("Creating object %s (class: %s)", dn, strings.Join(classes, ","))
 
+	options := &createOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	tmp, err := s.getObject(dn, nil)
 	if err != nil {
 		return fmt.Errorf("createObject - talking to active directory failed: %s", err)
@@ -125,6 +179,13 @@ func (s *ADObjectServiceOp) createObject(dn string, classes []string, attributes
 	}
 
 	log.Info("Object created")
+
+	if options.protect {
+		if err := s.setProtection(dn, true); err != nil {
+			return fmt.Errorf("createObject - object %s created but protecting it failed: %s", dn, err)
+		}
+	}
+
 	return nil
 }
 
@@ -147,6 +208,11 @@ func (s *ADObjectServiceOp) deleteObject(dn string) error {
 
 	// delete object from ad
 	if err := s.client.client.conn.Del(req); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInsufficientAccessRights) {
+			if protected, perr := s.getProtection(dn); perr == nil && protected {
+				return &ProtectedObjectError{DN: dn}
+			}
+		}
 		return fmt.Errorf("deleteObject - failed to delete object %s: %s", dn, err)
 	}
 
@@ -154,8 +220,10 @@ func (s *ADObjectServiceOp) deleteObject(dn string) error {
 	return nil
 }
 
-// Update updates a ad object
-func (s *ADObjectServiceOp) updateObject(dn string, classes []string, added, changed, removed map[string][]string) error {
+// Update updates a ad object. Deletes are sent before replaces and adds, so
+// removing an old value and adding a new one of the same attribute works in
+// a single modify. The ldap error is wrapped and can be inspected with ldapResultCode.
+func (s *ADObjectServiceOp) updateObject(dn string, classes []string, added, changed, removed map[string][]string, controls ...ldap.Control) error {
 	log.Infof("Updating object %s", dn)
 
 	tmp, err := s.getObject(dn, nil)
@@ -167,28 +235,276 @@ func (s *ADObjectServiceOp) updateObject(dn string, classes []string, added, cha
 		return fmt.Errorf("updateObject - object %s does not exist", dn)
 	}
 
-	req := ldap.NewModifyRequest(dn, nil)
+	req := ldap.NewModifyRequest(dn, controls)
 
 	if classes != nil {
 		req.Replace("objectClass", classes)
 	}
 
-	for key, value := range added {
-		req.Add(key, value)
+	for key, value := range removed {
+		req.Delete(key, value)
 	}
 
 	for key, value := range changed {
 		req.Replace(key, value)
 	}
 
-	for key, value := range removed {
-		req.Delete(key, value)
+	for key, value := range added {
+		req.Add(key, value)
 	}
 
 	if err := s.client.client.conn.Modify(req); err != nil {
-		return fmt.Errorf("updateObject - failed to update %s: %s", dn, err)
+		return fmt.Errorf("updateObject - failed to update %s: %w", dn, err)
 	}
 
 	log.Info("Object updated")
 	return nil
 }
+
+// LDAP_SERVER_SD_FLAGS_OID, limits nTSecurityDescriptor reads and writes to the dacl
+const sdFlagsControlOID = "1.2.840.113556.1.4.801"
+
+// dacl only, encoded as SEQUENCE { INTEGER 4 }
+var daclSecurityInformation = string([]byte{0x30, 0x03, 0x02, 0x01, 0x04})
+
+// the access rights adsiedit denies to everyone when an object is protected
+const protectionMask = helper.ADSRightDelete | helper.ADSRightDSDeleteTree
+
+var everyoneSID = helper.SID{RevisionLevel: 1, SubAuthorityCount: 1, Authority: 1, SubAuthorities: []int{0}}
+
+// ProtectedObjectError is returned when a delete is blocked by "protect object from accidental deletion"
+type ProtectedObjectError struct {
+	DN string
+}
+
+func (e *ProtectedObjectError) Error() string {
+	return fmt.Sprintf("object %s is protected from accidental deletion", e.DN)
+}
+
+// reads the dacl of an object
+func (s *ADObjectServiceOp) getSecurityDescriptor(dn string) (*helper.SecurityDescriptor, error) {
+	controls := []ldap.Control{ldap.NewControlString(sdFlagsControlOID, true, daclSecurityInformation)}
+
+	objects, err := s.search("(objectclass=*)", dn, ldap.ScopeBaseObject, []string{"nTSecurityDescriptor"}, controls, 0)
+	if err != nil {
+		return nil, fmt.Errorf("getSecurityDescriptor - failed to read security descriptor of %s: %s", dn, err)
+	}
+
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("getSecurityDescriptor - object %s does not exist", dn)
+	}
+
+	values := objects[0].attributes["nTSecurityDescriptor"]
+	if len(values) == 0 {
+		return nil, fmt.Errorf("getSecurityDescriptor - no security descriptor returned for %s", dn)
+	}
+
+	sd, err := helper.DecodeSecurityDescriptor([]byte(values[0]))
+	if err != nil {
+		return nil, fmt.Errorf("getSecurityDescriptor - failed to decode security descriptor of %s: %s", dn, err)
+	}
+
+	return sd, nil
+}
+
+// writes back the dacl of an object
+func (s *ADObjectServiceOp) setSecurityDescriptor(dn string, sd *helper.SecurityDescriptor) error {
+	controls := []ldap.Control{ldap.NewControlString(sdFlagsControlOID, true, daclSecurityInformation)}
+
+	req := ldap.NewModifyRequest(dn, controls)
+	req.Replace("nTSecurityDescriptor", []string{string(sd.Encode())})
+
+	if err := s.client.client.conn.Modify(req); err != nil {
+		return fmt.Errorf("setSecurityDescriptor - failed to update security descriptor of %s: %s", dn, err)
+	}
+
+	return nil
+}
+
+// reports whether an object is protected from accidental deletion
+func (s *ADObjectServiceOp) getProtection(dn string) (bool, error) {
+	sd, err := s.getSecurityDescriptor(dn)
+	if err != nil {
+		return false, fmt.Errorf("getProtection - %s", err)
+	}
+
+	return isProtected(sd), nil
+}
+
+func isProtected(sd *helper.SecurityDescriptor) bool {
+	if sd.Dacl == nil {
+		return false
+	}
+
+	everyone := string(everyoneSID.Bytes())
+	var denied uint32
+	for _, ace := range sd.Dacl.Aces {
+		if ace.Type == helper.AccessDeniedACEType && ace.Flags&helper.InheritOnlyACE == 0 && string(ace.SID) == everyone {
+			denied |= ace.Mask
+		}
+	}
+
+	return denied&protectionMask == protectionMask
+}
+
+// adds or removes the everyone deny delete ace that protects an object from accidental deletion
+func (s *ADObjectServiceOp) setProtection(dn string, protect bool) error {
+	log.Infof("Setting deletion protection of %s to %t", dn, protect)
+
+	sd, err := s.getSecurityDescriptor(dn)
+	if err != nil {
+		return fmt.Errorf("setProtection - %s", err)
+	}
+
+	if protect && isProtected(sd) {
+		log.Info("Object is already protected")
+		return nil
+	}
+
+	if sd.Dacl == nil {
+		sd.Dacl = &helper.ACL{Revision: 4}
+	}
+
+	everyone := string(everyoneSID.Bytes())
+	aces := make([]*helper.ACE, 0, len(sd.Dacl.Aces)+1)
+	changed := false
+	for _, ace := range sd.Dacl.Aces {
+		explicit := ace.Flags&helper.InheritedACE == 0
+		if !protect && explicit && ace.Type == helper.AccessDeniedACEType && string(ace.SID) == everyone && ace.Mask&protectionMask != 0 {
+			ace.Mask &^= protectionMask
+			changed = true
+			if ace.Mask == 0 {
+				continue
+			}
+		}
+		aces = append(aces, ace)
+	}
+
+	if protect {
+		// explicit deny aces go first in a canonical dacl
+		aces = append([]*helper.ACE{{
+			Type: helper.AccessDeniedACEType,
+			Mask: protectionMask,
+			SID:  everyoneSID.Bytes(),
+		}}, aces...)
+		changed = true
+	}
+
+	if !changed {
+		log.Info("Object is already unprotected")
+		return nil
+	}
+
+	sd.Dacl.Aces = aces
+	if err := s.setSecurityDescriptor(dn, sd); err != nil {
+		return fmt.Errorf("setProtection - %s", err)
+	}
+
+	log.Info("Object protection updated")
+	return nil
+}
+
+// page size for searches that may return more entries than the server size limit
+const searchPageSize = 1000
+
+// returns the ldap result code of an error, looking through wrapped errors, or 0
+func ldapResultCode(err error) uint16 {
+	var lerr *ldap.Error
+	if errors.As(err, &lerr) {
+		return lerr.ResultCode
+	}
+	return 0
+}
+
+// returns the first value of an attribute, or an empty string when it is not set
+func (o *ADObject) value(name string) string {
+	values := o.values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// returns all values of an attribute, ignoring the case of the attribute name
+func (o *ADObject) values(name string) []string {
+	if v, ok := o.attributes[name]; ok {
+		return v
+	}
+
+	for key, v := range o.attributes {
+		if strings.EqualFold(key, name) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// splits a dn into its first rdn and the dn of its parent, both escaped
+func splitDN(dn string) (string, string, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid dn %q: %s", dn, err)
+	}
+
+	if len(parsed.RDNs) == 0 {
+		return "", "", fmt.Errorf("invalid dn %q: empty dn", dn)
+	}
+
+	rdns := make([]string, len(parsed.RDNs))
+	for i, rdn := range parsed.RDNs {
+		parts := make([]string, len(rdn.Attributes))
+		for j, attr := range rdn.Attributes {
+			parts[j] = fmt.Sprintf("%s=%s", attr.Type, helper.EscapeDN(attr.Value))
+		}
+		rdns[i] = strings.Join(parts, "+")
+	}
+
+	return rdns[0], strings.Join(rdns[1:], ","), nil
+}
+
+// returns dn with attribute types and values lower cased and escaping made
+// uniform, for use as a map key. Unparsable dns are only lower cased.
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+
+	rdns := make([]string, len(parsed.RDNs))
+	for i, rdn := range parsed.RDNs {
+		parts := make([]string, len(rdn.Attributes))
+		for j, attr := range rdn.Attributes {
+			parts[j] = strings.ToLower(attr.Type) + "=" + helper.EscapeDN(strings.ToLower(attr.Value))
+		}
+		rdns[i] = strings.Join(parts, "+")
+	}
+	return strings.Join(rdns, ",")
+}
+
+// compares two dns the way active directory does, ignoring case and escaping differences
+func equalDN(a, b string) bool {
+	pa, err := ldap.ParseDN(a)
+	if err != nil {
+		return strings.EqualFold(a, b)
+	}
+
+	pb, err := ldap.ParseDN(b)
+	if err != nil || len(pa.RDNs) != len(pb.RDNs) {
+		return false
+	}
+
+	for i := range pa.RDNs {
+		if len(pa.RDNs[i].Attributes) != len(pb.RDNs[i].Attributes) {
+			return false
+		}
+		for j := range pa.RDNs[i].Attributes {
+			x, y := pa.RDNs[i].Attributes[j], pb.RDNs[i].Attributes[j]
+			if !strings.EqualFold(x.Type, y.Type) || !strings.EqualFold(x.Value, y.Value) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
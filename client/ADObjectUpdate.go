@@ -0,0 +1,265 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/ldap.v3"
+)
+
+// LDAP assertion control (RFC 4528)
+const assertionControlOID = "1.3.6.1.1.12"
+
+// ConflictError is returned by conditional updates when the object was changed
+// by someone else since it was read
+type ConflictError struct {
+	DN string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("object %s was changed since it was read", e.DN)
+}
+
+// updates an object to the desired attribute values with a minimal modify.
+// Attributes missing from desired are left alone, attributes mapped to an
+// empty list are cleared. Attributes with more than one current or desired
+// value are treated as sets and only the differing values are added and
+// deleted. Values are compared exactly so case-only changes are written,
+// active directory keeps the case it is given. Most string attributes compare
+// case-insensitively in active directory, so desired values of one attribute
+// must not differ only in case unless its syntax is case-exact.
+func (s *ADObjectServiceOp) updateAttributes(dn string, desired map[string][]string) error {
+	log.Infof("Updating attributes of %s", dn)
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	current, err := s.getObject(dn, append(names, "uSNChanged"))
+	if err != nil {
+		return fmt.Errorf("updateAttributes - talking to active directory failed: %s", err)
+	}
+
+	if current == nil {
+		return fmt.Errorf("updateAttributes - object %s does not exist", dn)
+	}
+
+	// guards the window between the read above and the modify
+	return s.updateAttributesIfUnchanged(current, desired)
+}
+
+// applies desired to an object read earlier, the diff is computed against what
+// was read and the modify fails with a ConflictError when the object changed
+// since. Include uSNChanged when reading the object so the whole object can be
+// guarded, otherwise only the modified attributes are.
+func (s *ADObjectServiceOp) updateAttributesIfUnchanged(read *ADObject, desired map[string][]string) error {
+	log.Infof("Conditionally updating attributes of %s", read.dn)
+
+	added, changed, removed := diffAttributes(read, desired)
+	if len(added) == 0 && len(changed) == 0 && len(removed) == 0 {
+		log.Info("Object is already up to date")
+		return nil
+	}
+
+	var controls []ldap.Control
+	usn := read.value("uSNChanged")
+	if usn != "" && s.supportsAssertion() {
+		filter, err := ldap.CompileFilter(fmt.Sprintf("(uSNChanged=%s)", ldap.EscapeFilter(usn)))
+		if err != nil {
+			return fmt.Errorf("updateAttributesIfUnchanged - failed to build assertion: %s", err)
+		}
+		controls = []ldap.Control{ldap.NewControlString(assertionControlOID, true, string(filter.Bytes()))}
+	} else {
+		added, changed, removed = guardWithReadValues(read, added, changed, removed)
+	}
+
+	if err := s.updateObject(read.dn, nil, added, changed, removed, controls...); err != nil {
+		switch ldapResultCode(err) {
+		case ldap.LDAPResultAssertionFailed, ldap.LDAPResultNoSuchAttribute, ldap.LDAPResultAttributeOrValueExists:
+			return &ConflictError{DN: read.dn}
+		}
+		return fmt.Errorf("updateAttributesIfUnchanged - %s", err)
+	}
+
+	return nil
+}
+
+// turns replaces and clears into deletes of exactly the values that were read
+// followed by adds. The modify then fails with noSuchAttribute when any of
+// them was changed in the meantime.
+func guardWithReadValues(read *ADObject, added, changed, removed map[string][]string) (map[string][]string, map[string][]string, map[string][]string) {
+	guardedAdded := make(map[string][]string, len(added)+len(changed))
+	guardedRemoved := make(map[string][]string, len(removed)+len(changed))
+
+	for key, value := range added {
+		guardedAdded[key] = value
+	}
+
+	for key, value := range removed {
+		if len(value) == 0 {
+			value = read.values(key)
+		}
+		guardedRemoved[key] = value
+	}
+
+	for key, value := range changed {
+		if old := read.values(key); len(old) > 0 {
+			guardedRemoved[key] = old
+		}
+		guardedAdded[key] = value
+	}
+
+	return guardedAdded, nil, guardedRemoved
+}
+
+// reports whether the server supports the assertion control, the rootDSE is
+// read once per service and a failed read counts as unsupported. Active
+// directory domain controllers do not advertise 1.3.6.1.1.12, so against them
+// the value-delete fallback of updateAttributesIfUnchanged is what actually
+// detects concurrent changes.
+func (s *ADObjectServiceOp) supportsAssertion() bool {
+	s.assertionOnce.Do(func() {
+		rootDSE, err := s.getObject("", []string{"supportedControl"})
+		if err != nil {
+			log.Warnf("Failed to read supported controls, not using assertions: %s", err)
+			return
+		}
+
+		if rootDSE != nil {
+			for _, oid := range rootDSE.values("supportedControl") {
+				if oid == assertionControlOID {
+					s.assertionSupported = true
+				}
+			}
+		}
+	})
+	return s.assertionSupported
+}
+
+// computes the modifications that turn the current attributes into the desired ones
+func diffAttributes(current *ADObject, desired map[string][]string) (added, changed, removed map[string][]string) {
+	added = make(map[string][]string)
+	changed = make(map[string][]string)
+	removed = make(map[string][]string)
+
+	for name, want := range desired {
+		have := current.values(name)
+
+		switch {
+		case len(want) == 0:
+			if len(have) > 0 {
+				removed[name] = []string{}
+			}
+
+		case len(have) == 0:
+			changed[name] = want
+
+		case len(have) == 1 && len(want) == 1:
+			if have[0] != want[0] {
+				changed[name] = want
+			}
+
+		default:
+			if add := subtractValues(want, have); len(add) > 0 {
+				added[name] = add
+			}
+			if del := subtractValues(have, want); len(del) > 0 {
+				removed[name] = del
+			}
+		}
+	}
+
+	return added, changed, removed
+}
+
+// returns the values of a that are not in b, compared exactly like single values
+func subtractValues(a, b []string) []string {
+	set := make(map[string]bool, len(b))
+	for _, v := range b {
+		set[v] = true
+	}
+
+	var out []string
+	for _, v := range a {
+		if !set[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// converts a struct into desired attributes for updateAttributes. Fields are
+// mapped by their `ldap:"name"` tag, untagged fields are ignored. Supported
+// field types are string, []string, int, bool and pointers to them, nil
+// pointers leave the attribute alone and empty values clear it.
+func desiredAttributes(v interface{}) (map[string][]string, error) {
+	if m, ok := v.(map[string][]string); ok {
+		return m, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("desiredAttributes - expected a struct or map[string][]string, got %T", v)
+	}
+
+	desired := make(map[string][]string)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name := rt.Field(i).Tag.Get("ldap")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		field := rv.Field(i)
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			if field.String() == "" {
+				desired[name] = []string{}
+			} else {
+				desired[name] = []string{field.String()}
+			}
+		case reflect.Slice:
+			values, ok := field.Interface().([]string)
+			if !ok {
+				return nil, fmt.Errorf("desiredAttributes - field %s has unsupported type %s", rt.Field(i).Name, field.Type())
+			}
+			desired[name] = append([]string{}, values...)
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			desired[name] = []string{strconv.FormatInt(field.Int(), 10)}
+		case reflect.Bool:
+			desired[name] = []string{strings.ToUpper(strconv.FormatBool(field.Bool()))}
+		default:
+			return nil, fmt.Errorf("desiredAttributes - field %s has unsupported type %s", rt.Field(i).Name, field.Type())
+		}
+	}
+
+	return desired, nil
+}
+
+// updates an object from a struct with ldap tags or a map[string][]string
+func (s *ADObjectServiceOp) update(dn string, desired interface{}) error {
+	attributes, err := desiredAttributes(desired)
+	if err != nil {
+		return err
+	}
+	return s.updateAttributes(dn, attributes)
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDiffAttributes(t *testing.T) {
+	current := &ADObject{
+		dn: "CN=test,DC=example,DC=com",
+		attributes: map[string][]string{
+			"description":    {"old"},
+			"displayName":    {"Test"},
+			"mail":           {"test@example.com"},
+			"otherTelephone": {"1", "2"},
+			"memberUid":      {"alice", "bob"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		desired map[string][]string
+		added   map[string][]string
+		changed map[string][]string
+		removed map[string][]string
+	}{
+		{
+			name:    "unchanged",
+			desired: map[string][]string{"description": {"old"}, "otherTelephone": {"2", "1"}},
+		},
+		{
+			name:    "attribute name is case insensitive",
+			desired: map[string][]string{"DisplayName": {"Test"}},
+		},
+		{
+			name:    "set",
+			desired: map[string][]string{"title": {"Engineer"}},
+			changed: map[string][]string{"title": {"Engineer"}},
+		},
+		{
+			name:    "change",
+			desired: map[string][]string{"description": {"new"}},
+			changed: map[string][]string{"description": {"new"}},
+		},
+		{
+			name:    "case only change",
+			desired: map[string][]string{"displayName": {"TEST"}},
+			changed: map[string][]string{"displayName": {"TEST"}},
+		},
+		{
+			name:    "clear",
+			desired: map[string][]string{"mail": {}},
+			removed: map[string][]string{"mail": {}},
+		},
+		{
+			name:    "clear unset attribute",
+			desired: map[string][]string{"title": {}},
+		},
+		{
+			name:    "multi valued",
+			desired: map[string][]string{"otherTelephone": {"2", "3"}},
+			added:   map[string][]string{"otherTelephone": {"3"}},
+			removed: map[string][]string{"otherTelephone": {"1"}},
+		},
+		{
+			// memberUid is an IA5 string, which active directory compares case-exact
+			name:    "case exact multi valued",
+			desired: map[string][]string{"memberUid": {"alice", "Bob"}},
+			added:   map[string][]string{"memberUid": {"Bob"}},
+			removed: map[string][]string{"memberUid": {"bob"}},
+		},
+	}
+
+	for _, tt := range tests {
+		added, changed, removed := diffAttributes(current, tt.desired)
+		check := func(kind string, got, want map[string][]string) {
+			if want == nil {
+				want = map[string][]string{}
+			}
+			for _, v := range got {
+				sort.Strings(v)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s = %v, want %v", tt.name, kind, got, want)
+			}
+		}
+		check("added", added, tt.added)
+		check("changed", changed, tt.changed)
+		check("removed", removed, tt.removed)
+	}
+}
+
+func TestGuardWithReadValues(t *testing.T) {
+	read := &ADObject{
+		dn: "CN=test,DC=example,DC=com",
+		attributes: map[string][]string{
+			"description": {"old"},
+			"mail":        {"test@example.com"},
+		},
+	}
+
+	added, changed, removed := guardWithReadValues(read,
+		map[string][]string{"otherTelephone": {"3"}},
+		map[string][]string{"description": {"new"}, "title": {"Engineer"}},
+		map[string][]string{"mail": {}})
+
+	if changed != nil {
+		t.Errorf("changed = %v, want nil", changed)
+	}
+
+	wantAdded := map[string][]string{"otherTelephone": {"3"}, "description": {"new"}, "title": {"Engineer"}}
+	if !reflect.DeepEqual(added, wantAdded) {
+		t.Errorf("added = %v, want %v", added, wantAdded)
+	}
+
+	wantRemoved := map[string][]string{"description": {"old"}, "mail": {"test@example.com"}}
+	if !reflect.DeepEqual(removed, wantRemoved) {
+		t.Errorf("removed = %v, want %v", removed, wantRemoved)
+	}
+}
+
+func TestDesiredAttributes(t *testing.T) {
+	type account struct {
+		Description string   `ldap:"description"`
+		Mail        *string  `ldap:"mail"`
+		Title       *string  `ldap:"title"`
+		Phones      []string `ldap:"otherTelephone"`
+		Flags       int      `ldap:"userAccountControl"`
+		Critical    bool     `ldap:"isCriticalSystemObject"`
+		Ignored     string   `ldap:"-"`
+		Untagged    string
+	}
+
+	empty := ""
+	got, err := desiredAttributes(&account{
+		Description: "",
+		Title:       &empty,
+		Phones:      []string{"1", "2"},
+		Flags:       512,
+		Critical:    true,
+		Ignored:     "x",
+		Untagged:    "y",
+	})
+	if err != nil {
+		t.Fatalf("desiredAttributes: %s", err)
+	}
+
+	want := map[string][]string{
+		"description":            {},
+		"title":                  {},
+		"otherTelephone":         {"1", "2"},
+		"userAccountControl":     {"512"},
+		"isCriticalSystemObject": {"TRUE"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("desiredAttributes = %v, want %v", got, want)
+	}
+
+	m := map[string][]string{"description": {"x"}}
+	if got, err := desiredAttributes(m); err != nil || !reflect.DeepEqual(got, m) {
+		t.Errorf("desiredAttributes(map) = %v, %v", got, err)
+	}
+
+	if _, err := desiredAttributes("description"); err == nil {
+		t.Error("desiredAttributes(string) should fail")
+	}
+
+	type unsupported struct {
+		Sizes []int `ldap:"sizes"`
+	}
+	if _, err := desiredAttributes(unsupported{Sizes: []int{1}}); err == nil {
+		t.Error("desiredAttributes with []int should fail")
+	}
+}
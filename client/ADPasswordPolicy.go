@@ -0,0 +1,153 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+)
+
+// reads the default password policy from the domain object
+func (s *ADUserServiceOp) getDomainPasswordPolicy() (*helper.PasswordPolicy, error) {
+	domain := s.client.getDomainDN()
+	log.Infof("Reading password policy of %s", domain)
+
+	obj, err := s.client.ADObject.getObject(domain, []string{"minPwdLength", "pwdProperties", "pwdHistoryLength", "minPwdAge", "maxPwdAge"})
+	if err != nil {
+		return nil, fmt.Errorf("getDomainPasswordPolicy - failed to read %s: %s", domain, err)
+	}
+
+	if obj == nil {
+		return nil, fmt.Errorf("getDomainPasswordPolicy - domain object %s not found", domain)
+	}
+
+	properties, _ := strconv.Atoi(obj.value("pwdProperties"))
+
+	return &helper.PasswordPolicy{
+		MinLength:         intValue(obj, "minPwdLength"),
+		ComplexityEnabled: properties&helper.DomainPasswordComplex != 0,
+		HistoryLength:     intValue(obj, "pwdHistoryLength"),
+		MinAge:            helper.IntervalToDuration(int64Value(obj, "minPwdAge")),
+		MaxAge:            helper.IntervalToDuration(int64Value(obj, "maxPwdAge")),
+	}, nil
+}
+
+// returns the policy that applies to a user: the fine-grained password settings
+// object reported by msDS-ResultantPSO, or the domain policy when none applies
+func (s *ADUserServiceOp) getPasswordPolicy(dn string) (*helper.PasswordPolicy, error) {
+	user, err := s.client.ADObject.getObject(dn, []string{"msDS-ResultantPSO"})
+	if err != nil {
+		return nil, fmt.Errorf("getPasswordPolicy - failed to read %s: %s", dn, err)
+	}
+
+	if user == nil {
+		return nil, fmt.Errorf("getPasswordPolicy - user %s not found", dn)
+	}
+
+	psoDN := user.value("msDS-ResultantPSO")
+	if psoDN == "" {
+		return s.getDomainPasswordPolicy()
+	}
+
+	log.Infof("Reading password settings object %s", psoDN)
+	pso, err := s.client.ADObject.getObject(psoDN, []string{
+		"msDS-MinimumPasswordLength", "msDS-PasswordComplexityEnabled", "msDS-PasswordHistoryLength",
+		"msDS-MinimumPasswordAge", "msDS-MaximumPasswordAge",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getPasswordPolicy - failed to read %s: %s", psoDN, err)
+	}
+
+	// msDS-ResultantPSO is readable by the user, the pso itself only by admins
+	if pso == nil {
+		log.Warnf("Password settings object %s is not readable, using the domain policy", psoDN)
+		return s.getDomainPasswordPolicy()
+	}
+
+	return &helper.PasswordPolicy{
+		Source:            psoDN,
+		MinLength:         intValue(pso, "msDS-MinimumPasswordLength"),
+		ComplexityEnabled: pso.value("msDS-PasswordComplexityEnabled") == "TRUE",
+		HistoryLength:     intValue(pso, "msDS-PasswordHistoryLength"),
+		MinAge:            helper.IntervalToDuration(int64Value(pso, "msDS-MinimumPasswordAge")),
+		MaxAge:            helper.IntervalToDuration(int64Value(pso, "msDS-MaximumPasswordAge")),
+	}, nil
+}
+
+// checks a candidate password for an existing user against its effective policy
+func (s *ADUserServiceOp) validatePassword(dn, password string) error {
+	user, err := s.client.ADObject.getObject(dn, []string{"sAMAccountName", "displayName"})
+	if err != nil {
+		return fmt.Errorf("validatePassword - failed to read %s: %s", dn, err)
+	}
+
+	if user == nil {
+		return fmt.Errorf("validatePassword - user %s not found", dn)
+	}
+
+	policy, err := s.getPasswordPolicy(dn)
+	if err != nil {
+		return fmt.Errorf("validatePassword - %s", err)
+	}
+
+	return helper.ValidatePassword(policy, password, user.value("sAMAccountName"), user.value("displayName"))
+}
+
+// checks a password before it is sent to the domain controller. Failing to read
+// the policy is not fatal, the domain controller enforces it anyway.
+func (s *ADUserServiceOp) precheckPassword(dn, password string) error {
+	err := s.validatePassword(dn, password)
+	if _, ok := err.(*helper.PasswordPolicyError); ok {
+		return err
+	}
+
+	if err != nil {
+		log.Warnf("Skipping local password policy check: %s", err)
+	}
+	return nil
+}
+
+func intValue(obj *ADObject, name string) int {
+	v, _ := strconv.Atoi(obj.value(name))
+	return v
+}
+
+func int64Value(obj *ADObject, name string) int64 {
+	v, _ := strconv.ParseInt(obj.value(name), 10, 64)
+	return v
+}
+
+// generates a random password satisfying the policy of an existing account, or
+// the domain policy when dn is empty (e.g. for an account about to be created,
+// in which case opts should carry its account and display name)
+func (s *ADUserServiceOp) generatePassword(dn string, opts helper.PasswordOptions) (string, error) {
+	var policy *helper.PasswordPolicy
+	var err error
+
+	if dn == "" {
+		policy, err = s.getDomainPasswordPolicy()
+	} else {
+		policy, err = s.getPasswordPolicy(dn)
+		if err == nil && opts.AccountName == "" && opts.DisplayName == "" {
+			user, uerr := s.client.ADObject.getObject(dn, []string{"sAMAccountName", "displayName"})
+			if uerr != nil {
+				return "", fmt.Errorf("generatePassword - failed to read %s: %s", dn, uerr)
+			}
+			if user != nil {
+				opts.AccountName = user.value("sAMAccountName")
+				opts.DisplayName = user.value("displayName")
+			}
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("generatePassword - %s", err)
+	}
+
+	password, err := helper.GeneratePassword(policy, opts)
+	if err != nil {
+		return "", fmt.Errorf("generatePassword - %s", err)
+	}
+
+	return password, nil
+}
@@ -0,0 +1,402 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+	"gopkg.in/ldap.v3"
+)
+
+// PosixAttributes are the RFC2307 attributes of a user or group. Zero values
+// are left unchanged by setUserPosix, a zero UIDNumber or GIDNumber is
+// allocated when the object does not have one yet.
+type PosixAttributes struct {
+	UIDNumber         int
+	GIDNumber         int
+	LoginShell        string
+	UnixHomeDirectory string
+	Gecos             string
+}
+
+// IDAllocator hands out uidNumber and gidNumber values
+type IDAllocator interface {
+	AllocateID(dn string, sid helper.SID) (int, error)
+}
+
+// RIDAllocator derives ids from the relative id of the object sid. Forests
+// with several domains give every domain its own range, keyed by domain sid,
+// so the same rid in two domains does not collide.
+type RIDAllocator struct {
+	Offset int
+
+	// base id per domain sid (S-1-5-21-x-y-z), required for every domain once set
+	Ranges map[string]int
+
+	// size of each domain range, rids beyond it are rejected when non zero
+	RangeSize int
+}
+
+// AllocateID returns range base + offset + rid
+func (a *RIDAllocator) AllocateID(dn string, sid helper.SID) (int, error) {
+	if len(sid.SubAuthorities) < 2 {
+		return 0, fmt.Errorf("object %s has no domain sid", dn)
+	}
+
+	rid := sid.RID()
+	if a.RangeSize > 0 && a.Offset+rid >= a.RangeSize {
+		return 0, fmt.Errorf("rid %d of %s does not fit into the id range of size %d", rid, dn, a.RangeSize)
+	}
+
+	base := 0
+	if len(a.Ranges) > 0 {
+		domain := helper.SID{
+			RevisionLevel:     sid.RevisionLevel,
+			Authority:         sid.Authority,
+			SubAuthorities:    sid.SubAuthorities[:len(sid.SubAuthorities)-1],
+			SubAuthorityCount: len(sid.SubAuthorities) - 1,
+		}
+
+		var ok bool
+		if base, ok = a.Ranges[domain.String()]; !ok {
+			return 0, fmt.Errorf("no id range configured for domain %s of %s", domain, dn)
+		}
+	}
+
+	return base + a.Offset + rid, nil
+}
+
+// CounterAllocator hands out the next free id from a counter attribute on a
+// directory object, e.g. msSFU30MaxUidNumber on the NIS domain object. The
+// counter is advanced with an atomic compare-and-swap.
+type CounterAllocator struct {
+	DN        string
+	Attribute string
+
+	objects ADObjectService
+}
+
+// NewCounterAllocator returns a counter allocator working through the client
+func (c *Client) NewCounterAllocator(dn, attribute string) *CounterAllocator {
+	return &CounterAllocator{DN: dn, Attribute: attribute, objects: c.ADObject}
+}
+
+// maximum compare-and-swap retries when other clients allocate concurrently
+const maxAllocateAttempts = 20
+
+// AllocateID returns the current counter value and advances the counter
+func (a *CounterAllocator) AllocateID(dn string, sid helper.SID) (int, error) {
+	for i := 0; i < maxAllocateAttempts; i++ {
+		counter, err := a.objects.getObject(a.DN, []string{a.Attribute})
+		if err != nil {
+			return 0, fmt.Errorf("failed to read id counter %s: %s", a.DN, err)
+		}
+
+		if counter == nil {
+			return 0, fmt.Errorf("id counter object %s does not exist", a.DN)
+		}
+
+		current, err := strconv.Atoi(counter.value(a.Attribute))
+		if err != nil {
+			return 0, fmt.Errorf("id counter %s on %s is not a number: %s", a.Attribute, a.DN, err)
+		}
+
+		read := &ADObject{dn: a.DN, attributes: map[string][]string{a.Attribute: {strconv.Itoa(current)}}}
+		err = a.objects.updateAttributesIfUnchanged(read, map[string][]string{a.Attribute: {strconv.Itoa(current + 1)}})
+		if _, ok := err.(*ConflictError); ok {
+			log.Infof("Id counter %s changed concurrently, retrying", a.DN)
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to advance id counter %s: %s", a.DN, err)
+		}
+
+		return current, nil
+	}
+
+	return 0, fmt.Errorf("failed to allocate an id from %s after %d attempts", a.DN, maxAllocateAttempts)
+}
+
+type ADPosixService interface {
+	getPosixAttributes(dn string) (*PosixAttributes, error)
+	setUserPosix(dn string, attrs PosixAttributes) error
+	setGroupPosix(dn string, gidNumber int) error
+	setAllocators(uid, gid IDAllocator)
+	setForestWideCheck(enabled bool)
+}
+
+type ADPosixServiceOp struct {
+	client *Client
+
+	// default to rid + 1000, the numbering createUser has always used
+	uidAllocator IDAllocator
+	gidAllocator IDAllocator
+
+	// check ids for collisions in the whole forest through the global catalog
+	// instead of only in the domain
+	forestWide bool
+}
+
+var _ ADPosixService = &ADPosixServiceOp{}
+
+var defaultIDAllocator = &RIDAllocator{Offset: 1000}
+
+// replaces the uid and gid allocators, nil keeps the current one
+func (s *ADPosixServiceOp) setAllocators(uid, gid IDAllocator) {
+	if uid != nil {
+		s.uidAllocator = uid
+	}
+	if gid != nil {
+		s.gidAllocator = gid
+	}
+}
+
+// checks ids against the whole forest through the global catalog, which the
+// configured host then has to be. Off by default, ids are checked in the domain.
+func (s *ADPosixServiceOp) setForestWideCheck(enabled bool) {
+	s.forestWide = enabled
+}
+
+// returns the posix attributes of a user or group
+func (s *ADPosixServiceOp) getPosixAttributes(dn string) (*PosixAttributes, error) {
+	obj, err := s.client.ADObject.getObject(dn, []string{"uidNumber", "gidNumber", "loginShell", "unixHomeDirectory", "gecos"})
+	if err != nil {
+		return nil, fmt.Errorf("getPosixAttributes - talking to active directory failed: %s", err)
+	}
+
+	if obj == nil {
+		return nil, fmt.Errorf("getPosixAttributes - object %s does not exist", dn)
+	}
+
+	return &PosixAttributes{
+		UIDNumber:         intValue(obj, "uidNumber"),
+		GIDNumber:         intValue(obj, "gidNumber"),
+		LoginShell:        obj.value("loginShell"),
+		UnixHomeDirectory: obj.value("unixHomeDirectory"),
+		Gecos:             obj.value("gecos"),
+	}, nil
+}
+
+// sets the posix attributes of a user, allocating a uidNumber when it has none
+func (s *ADPosixServiceOp) setUserPosix(dn string, attrs PosixAttributes) error {
+	log.Infof("Setting posix attributes of user %s", dn)
+
+	current, err := s.getPosixAttributes(dn)
+	if err != nil {
+		return fmt.Errorf("setUserPosix - %s", err)
+	}
+
+	desired := make(map[string][]string)
+	ids := s.newIDChecker()
+	defer ids.close()
+
+	uid := attrs.UIDNumber
+	if uid == 0 && current.UIDNumber == 0 {
+		if uid, err = s.allocate(ids, dn, "uidNumber", s.uidAllocator); err != nil {
+			return fmt.Errorf("setUserPosix - %s", err)
+		}
+	} else if uid != 0 && uid != current.UIDNumber {
+		if err := ids.checkCollision(dn, "uidNumber", uid); err != nil {
+			return fmt.Errorf("setUserPosix - %s", err)
+		}
+	}
+	if uid != 0 && uid != current.UIDNumber {
+		desired["uidNumber"] = []string{strconv.Itoa(uid)}
+	}
+
+	if attrs.GIDNumber != 0 {
+		desired["gidNumber"] = []string{strconv.Itoa(attrs.GIDNumber)}
+	}
+
+	optional := map[string]string{
+		"loginShell":        attrs.LoginShell,
+		"unixHomeDirectory": attrs.UnixHomeDirectory,
+		"gecos":             attrs.Gecos,
+	}
+	for key, value := range optional {
+		if value != "" {
+			desired[key] = []string{value}
+		}
+	}
+
+	return s.client.ADObject.updateAttributes(dn, desired)
+}
+
+// sets the gidNumber of a group, allocating one when gidNumber is 0 and the group has none
+func (s *ADPosixServiceOp) setGroupPosix(dn string, gidNumber int) error {
+	log.Infof("Setting posix attributes of group %s", dn)
+
+	current, err := s.getPosixAttributes(dn)
+	if err != nil {
+		return fmt.Errorf("setGroupPosix - %s", err)
+	}
+
+	ids := s.newIDChecker()
+	defer ids.close()
+
+	if gidNumber == 0 {
+		if current.GIDNumber != 0 {
+			log.Infof("Group already has gidNumber %d", current.GIDNumber)
+			return nil
+		}
+		if gidNumber, err = s.allocate(ids, dn, "gidNumber", s.gidAllocator); err != nil {
+			return fmt.Errorf("setGroupPosix - %s", err)
+		}
+	} else {
+		if gidNumber == current.GIDNumber {
+			return nil
+		}
+		if err := ids.checkCollision(dn, "gidNumber", gidNumber); err != nil {
+			return fmt.Errorf("setGroupPosix - %s", err)
+		}
+	}
+
+	return s.client.ADObject.updateAttributes(dn, map[string][]string{
+		"gidNumber": {strconv.Itoa(gidNumber)},
+	})
+}
+
+// asks the allocator for ids until one is not used by another object
+func (s *ADPosixServiceOp) allocate(ids *idChecker, dn, attribute string, allocator IDAllocator) (int, error) {
+	if allocator == nil {
+		allocator = defaultIDAllocator
+	}
+
+	obj, err := s.client.ADObject.getObject(dn, []string{"objectSid"})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %s", dn, err)
+	}
+
+	if obj == nil {
+		return 0, fmt.Errorf("object %s does not exist", dn)
+	}
+
+	var sid helper.SID
+	if v := obj.value("objectSid"); v != "" {
+		if sid, err = helper.DecodeSID([]byte(v)); err != nil {
+			return 0, fmt.Errorf("invalid objectSid on %s: %s", dn, err)
+		}
+	}
+
+	previous := -1
+	for i := 0; i < maxAllocateAttempts; i++ {
+		id, err := allocator.AllocateID(dn, sid)
+		if err != nil {
+			return 0, fmt.Errorf("failed to allocate %s: %s", attribute, err)
+		}
+
+		err = ids.checkCollision(dn, attribute, id)
+		if err == nil {
+			log.Infof("Allocated %s %d for %s", attribute, id, dn)
+			return id, nil
+		}
+
+		// a deterministic allocator will keep returning the same id
+		if id == previous {
+			return 0, err
+		}
+		previous = id
+		log.Warnf("%s, trying the next id", err)
+	}
+
+	return 0, fmt.Errorf("failed to allocate a free %s after %d attempts", attribute, maxAllocateAttempts)
+}
+
+// objects an id is unique among: uidNumber among users, gidNumber among
+// groups. Users also carry the gidNumber of their primary group.
+var idObjectFilters = map[string]string{
+	"uidNumber": "(objectCategory=person)(objectClass=user)",
+	"gidNumber": "(objectClass=group)",
+}
+
+// returns the filter for objects of the kind that owns attribute using id
+func collisionFilter(attribute string, id int) (string, error) {
+	objects, ok := idObjectFilters[attribute]
+	if !ok {
+		return "", fmt.Errorf("%s is not an id attribute", attribute)
+	}
+	return fmt.Sprintf("(&%s(%s=%d))", objects, attribute, id), nil
+}
+
+// checks ids for collisions during one operation. With forest wide checks
+// the global catalog connection is opened on the first check and reused.
+type idChecker struct {
+	posix    *ADPosixServiceOp
+	gc       *ldap.Conn
+	forestDN string
+}
+
+func (s *ADPosixServiceOp) newIDChecker() *idChecker {
+	return &idChecker{posix: s}
+}
+
+func (c *idChecker) close() {
+	if c.gc != nil {
+		c.gc.Close()
+		c.gc = nil
+	}
+}
+
+// fails when another object already uses id. uidNumber and gidNumber are in
+// the partial attribute set, so with forest wide checks the global catalog
+// sees the objects of every domain.
+func (c *idChecker) checkCollision(dn, attribute string, id int) error {
+	filter, err := collisionFilter(attribute, id)
+	if err != nil {
+		return err
+	}
+
+	var used []string
+	if c.posix.forestWide {
+		if used, err = c.searchForest(filter); err != nil {
+			return fmt.Errorf("failed to check %s %d for collisions: %s", attribute, id, err)
+		}
+	} else {
+		ret, err := c.posix.client.ADObject.searchObject(filter, c.posix.client.getDomainDN(), []string{attribute})
+		if err != nil {
+			return fmt.Errorf("failed to check %s %d for collisions: %s", attribute, id, err)
+		}
+		for _, obj := range ret {
+			used = append(used, obj.dn)
+		}
+	}
+
+	for _, other := range used {
+		if !equalDN(other, dn) {
+			return fmt.Errorf("%s %d is already used by %s", attribute, id, other)
+		}
+	}
+
+	return nil
+}
+
+// returns the dns matching filter in the global catalog
+func (c *idChecker) searchForest(filter string) ([]string, error) {
+	if c.gc == nil {
+		gc, forestDN, err := c.posix.client.globalCatalog()
+		if err != nil {
+			return nil, err
+		}
+		c.gc, c.forestDN = gc, forestDN
+	}
+
+	req := ldap.NewSearchRequest(
+		c.forestDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{"distinguishedName"},
+		nil,
+	)
+
+	ret, err := c.gc.SearchWithPaging(req, searchPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	dns := make([]string, len(ret.Entries))
+	for i, entry := range ret.Entries {
+		dns[i] = entry.DN
+	}
+	return dns, nil
+}
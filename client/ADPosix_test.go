@@ -0,0 +1,25 @@
+package client
+
+import "testing"
+
+func TestCollisionFilter(t *testing.T) {
+	tests := []struct {
+		attribute string
+		id        int
+		filter    string
+	}{
+		{"uidNumber", 10001, "(&(objectCategory=person)(objectClass=user)(uidNumber=10001))"},
+		{"gidNumber", 513, "(&(objectClass=group)(gidNumber=513))"},
+	}
+
+	for _, tt := range tests {
+		filter, err := collisionFilter(tt.attribute, tt.id)
+		if err != nil || filter != tt.filter {
+			t.Errorf("collisionFilter(%s, %d) = %q, %v, want %q", tt.attribute, tt.id, filter, err, tt.filter)
+		}
+	}
+
+	if _, err := collisionFilter("employeeNumber", 1); err == nil {
+		t.Error("collisionFilter(employeeNumber) should fail")
+	}
+}
@@ -1,67 +1,120 @@
 package client
 
 import (
-	"encoding/base64"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/surajsub/winad-client-go/helper"
-	"golang.org/x/text/encoding/unicode"
 	"strconv"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"gopkg.in/ldap.v3"
 	"strings"
 )
 
-type ADUserRequest struct {
-	firstname     string
-	lastname      string
-	name          string
-	cn            string
-	sn            string
-	baseOU        string
-	description   string
-	email         string
-	newOU         string
-	newName       string
-	adAccountName string // This is the SAM Account Name
-	dn            string
-	cdir          string
-	given_name    string
-	password      string
-}
-
 // User is the base implementation of ad User  object
 type ADUser struct {
-	name string
-	dn   string
-	//description string
-	sn  string
-	sid string
+	name               string
+	dn                 string
+	description        string
+	sn                 string
+	sid                helper.SID
+	guid               helper.GUID
+	samAccountName     string
+	upn                string
+	mail               string
+	givenName          string
+	displayName        string
+	title              string
+	department         string
+	company            string
+	manager            string
+	employeeID         string
+	memberOf           []string
+	userAccountControl UserAccountControl
+	state              AccountState
+	pwdLastSet         time.Time
+	lastLogon          time.Time
+	accountExpires     time.Time
+	whenCreated        time.Time
+	whenChanged        time.Time
+
+	// raw values of everything that was read, including attributes without a typed field
+	attributes map[string][]string
+}
+
+// attributes read by user lookups when the caller does not select any
+var userAttributes = []string{
+	"name", "cn", "sAMAccountName", "userPrincipalName", "description", "sn", "givenName", "displayName",
+	"mail", "title", "department", "company", "manager", "employeeID", "memberOf", "objectSid", "objectGUID",
+	"userAccountControl", "msDS-User-Account-Control-Computed", "pwdLastSet", "lastLogon", "lastLogonTimestamp", "accountExpires", "whenCreated", "whenChanged",
+}
+
+// AmbiguousIdentifierError is returned when a user lookup matches more than one object
+type AmbiguousIdentifierError struct {
+	Attribute string
+	Value     string
+	DNs       []string
+}
+
+func (e *AmbiguousIdentifierError) Error() string {
+	return fmt.Sprintf("%s %q matches %d objects: %s", e.Attribute, e.Value, len(e.DNs), strings.Join(e.DNs, "; "))
 }
 
 type ADUserService interface {
-	getUser(name, baseou string) (*ADUser, error)
+	getUser(name, baseou string, attributes ...string) (*ADUser, error)
+	getUserBySAMAccountName(name, baseDN string, attributes ...string) (*ADUser, error)
+	getUserByUPN(upn, baseDN string, attributes ...string) (*ADUser, error)
+	getUserByMail(mail, baseDN string, attributes ...string) (*ADUser, error)
+	getUserByGUID(guid, baseDN string, attributes ...string) (*ADUser, error)
+	getUserBySID(sid, baseDN string, attributes ...string) (*ADUser, error)
 	createUser(createUser ADUserRequest) error
 	deleteUser(dn string) error
 	moveUser(cn, baseOU, newOU string) error
+	renameUser(cn, baseOU string, rename UserRename) error
+	setUserTemplate(baseOU string, tmpl *UserTemplate) error
+	resetPassword(dn, password string) error
+	changePassword(dn, oldPassword, newPassword string) error
+	setMustChangePassword(dn string, mustChange bool) error
+	getPasswordPolicy(dn string) (*helper.PasswordPolicy, error)
+	validatePassword(dn, password string) error
+	generatePassword(dn string, opts helper.PasswordOptions) (string, error)
+	enableUser(dn string) error
+	disableUser(dn string) error
+	unlockUser(dn string) error
+	setAccountExpires(dn string, expires time.Time) error
+	setSSHKeyConfig(attribute string, policy *helper.SSHKeyPolicy)
+	listSSHKeys(dn string) ([]*helper.SSHPublicKey, error)
+	addSSHKey(dn, key string) error
+	removeSSHKey(dn, key string) error
+	authorizedKeys(samAccountName string) (string, error)
+	effectiveGroups(dn string) ([]string, error)
+	tokenGroups(dn string, resolve bool) ([]TokenGroup, error)
 }
 
 type ADUserServiceOp struct {
 	client *Client
+
+	// user templates keyed by lower case base ou
+	templates   map[string]*UserTemplate
+	templatesMu sync.RWMutex
+
+	// where ssh keys are stored and which are accepted, defaults when empty
+	sshKeyAttribute string
+	sshKeyPolicy    *helper.SSHKeyPolicy
 }
 
 var _ ADUserService = &ADUserServiceOp{}
 
-// returns User object
-func (s *ADUserServiceOp) getUser(name, baseOU string) (*ADUser, error) {
+// returns User object, attributes selects what is read and defaults to userAttributes
+func (s *ADUserServiceOp) getUser(name, baseOU string, attributes ...string) (*ADUser, error) {
 	log.Infof("getting User  from the ad server %s in %s", name, baseOU)
 
-	attributes := []string{"name", "cn", "sAMAccountName", "description", "sn", "objectSid"}
-	filter := fmt.Sprintf("(&(objectclass=*)(cn=%s))", name)
+	filter := fmt.Sprintf("(&(objectclass=*)(cn=%s))", ldap.EscapeFilter(name))
 
 	// trying to get user object
-	ret, err := s.client.ADObject.searchObject(filter, baseOU, attributes)
+	ret, err := s.client.ADObject.searchObject(filter, baseOU, projectUserAttributes(attributes))
 	log.Infof("the filter is %s", filter)
 	if err != nil {
 		return nil, fmt.Errorf("getUser - failed to search %s in %s: %s", name, baseOU, err)
@@ -74,164 +127,546 @@ func (s *ADUserServiceOp) getUser(name, baseOU string) (*ADUser, error) {
 	if len(ret) > 1 {
 		return nil, fmt.Errorf("getUser - more than one user object with the same name under the same base ou found")
 	}
-	log.Infof("Printing the value %v", ret[0].attributes)
 
-	return &ADUser{
-		name: ret[0].attributes["cn"][0],
-		dn:   ret[0].dn,
-		//description: ret[0].attributes["description"][0],
-		sn:  ret[0].attributes["sn"][0],
-		sid: ret[0].attributes["objectSid"][0],
-	}, nil
+	return newADUser(ret[0]), nil
+}
+
+// returns the user with the given login name
+func (s *ADUserServiceOp) getUserBySAMAccountName(name, baseDN string, attributes ...string) (*ADUser, error) {
+	return s.findUser("sAMAccountName", name, fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(name)), baseDN, attributes)
+}
+
+// returns the user with the given user principal name
+func (s *ADUserServiceOp) getUserByUPN(upn, baseDN string, attributes ...string) (*ADUser, error) {
+	return s.findUser("userPrincipalName", upn, fmt.Sprintf("(userPrincipalName=%s)", ldap.EscapeFilter(upn)), baseDN, attributes)
+}
+
+// returns the user with the given primary or proxy email address
+func (s *ADUserServiceOp) getUserByMail(mail, baseDN string, attributes ...string) (*ADUser, error) {
+	escaped := ldap.EscapeFilter(mail)
+	return s.findUser("mail", mail, fmt.Sprintf("(|(mail=%s)(proxyAddresses=smtp:%s))", escaped, escaped), baseDN, attributes)
+}
+
+// returns the user with the given objectGUID
+func (s *ADUserServiceOp) getUserByGUID(guid, baseDN string, attributes ...string) (*ADUser, error) {
+	g, err := helper.ParseGUID(guid)
+	if err != nil {
+		return nil, fmt.Errorf("getUserByGUID - %s", err)
+	}
+
+	return s.findUser("objectGUID", guid, fmt.Sprintf("(objectGUID=%s)", helper.EscapeBinary(g[:])), baseDN, attributes)
+}
+
+// returns the user with the given objectSid
+func (s *ADUserServiceOp) getUserBySID(sid, baseDN string, attributes ...string) (*ADUser, error) {
+	parsed, err := helper.ParseSID(sid)
+	if err != nil {
+		return nil, fmt.Errorf("getUserBySID - %s", err)
+	}
+
+	return s.findUser("objectSid", sid, fmt.Sprintf("(objectSid=%s)", helper.EscapeBinary(parsed.Bytes())), baseDN, attributes)
+}
+
+// searches a single user object under baseDN, or the whole domain when baseDN is empty
+func (s *ADUserServiceOp) findUser(attribute, value, filter, baseDN string, attributes []string) (*ADUser, error) {
+	if baseDN == "" {
+		baseDN = s.client.getDomainDN()
+	}
+
+	log.Infof("Looking up user by %s %s in %s", attribute, value, baseDN)
+
+	filter = fmt.Sprintf("(&(objectCategory=person)(objectClass=user)%s)", filter)
+	ret, err := s.client.ADObject.searchObject(filter, baseDN, projectUserAttributes(attributes))
+	if err != nil {
+		return nil, fmt.Errorf("findUser - failed to search user by %s %s in %s: %s", attribute, value, baseDN, err)
+	}
+
+	if len(ret) == 0 {
+		return nil, nil
+	}
+
+	if len(ret) > 1 {
+		dns := make([]string, len(ret))
+		for i, obj := range ret {
+			dns[i] = obj.dn
+		}
+		return nil, &AmbiguousIdentifierError{Attribute: attribute, Value: value, DNs: dns}
+	}
+
+	return newADUser(ret[0]), nil
+}
+
+// returns the attributes to read for a user lookup
+func projectUserAttributes(attributes []string) []string {
+	if len(attributes) == 0 {
+		return userAttributes
+	}
+	return attributes
+}
+
+// converts a search result into a user, attributes missing on the object or
+// not selected by the lookup are left at their zero value
+func newADUser(obj *ADObject) *ADUser {
+	user := &ADUser{
+		name:           obj.value("cn"),
+		dn:             obj.dn,
+		description:    obj.value("description"),
+		sn:             obj.value("sn"),
+		samAccountName: obj.value("sAMAccountName"),
+		upn:            obj.value("userPrincipalName"),
+		mail:           obj.value("mail"),
+		givenName:      obj.value("givenName"),
+		displayName:    obj.value("displayName"),
+		title:          obj.value("title"),
+		department:     obj.value("department"),
+		company:        obj.value("company"),
+		manager:        obj.value("manager"),
+		employeeID:     obj.value("employeeID"),
+		memberOf:       obj.values("memberOf"),
+		attributes:     obj.attributes,
+	}
+
+	if v := obj.value("objectSid"); v != "" {
+		if sid, err := helper.DecodeSID([]byte(v)); err == nil {
+			user.sid = sid
+		} else {
+			log.Warnf("Ignoring invalid objectSid on %s: %s", obj.dn, err)
+		}
+	}
+
+	if v := obj.value("objectGUID"); v != "" {
+		if guid, err := helper.DecodeGUID([]byte(v)); err == nil {
+			user.guid = guid
+		} else {
+			log.Warnf("Ignoring invalid objectGUID on %s: %s", obj.dn, err)
+		}
+	}
+
+	if v := obj.value("userAccountControl"); v != "" {
+		if uac, err := strconv.Atoi(v); err == nil {
+			user.userAccountControl = UserAccountControl(uac)
+		} else {
+			log.Warnf("Ignoring invalid userAccountControl on %s: %s", obj.dn, err)
+		}
+	}
+
+	if v := obj.value("msDS-User-Account-Control-Computed"); v != "" {
+		if computed, err := strconv.Atoi(v); err == nil {
+			user.state = decodeComputedUAC(UserAccountControl(computed))
+		} else {
+			log.Warnf("Ignoring invalid msDS-User-Account-Control-Computed on %s: %s", obj.dn, err)
+		}
+	}
+
+	user.pwdLastSet = fileTimeValue(obj, "pwdLastSet")
+	user.accountExpires = fileTimeValue(obj, "accountExpires")
+	user.whenCreated = generalizedTimeValue(obj, "whenCreated")
+	user.whenChanged = generalizedTimeValue(obj, "whenChanged")
+
+	// lastLogon is per dc and not replicated, lastLogonTimestamp is replicated but lags behind
+	user.lastLogon = fileTimeValue(obj, "lastLogon")
+	if ts := fileTimeValue(obj, "lastLogonTimestamp"); ts.After(user.lastLogon) {
+		user.lastLogon = ts
+	}
+
+	return user
+}
+
+// decodes a FILETIME attribute, invalid values are logged and read as the zero time
+func fileTimeValue(obj *ADObject, name string) time.Time {
+	t, err := helper.ParseFileTime(obj.value(name))
+	if err != nil {
+		log.Warnf("Ignoring invalid %s on %s: %s", name, obj.dn, err)
+	}
+	return t
+}
+
+// decodes a generalized time attribute, invalid values are logged and read as the zero time
+func generalizedTimeValue(obj *ADObject, name string) time.Time {
+	t, err := helper.ParseGeneralizedTime(obj.value(name))
+	if err != nil {
+		log.Warnf("Ignoring invalid %s on %s: %s", name, obj.dn, err)
+	}
+	return t
 }
 
 // creates a new User object
 func (s *ADUserServiceOp) createUser(user_create ADUserRequest) error {
 
-	log.Infof("Creating User %s in %s along with the following username %s", user_create.name, user_create.baseOU, user_create.email)
+	log.Infof("Creating User %s in %s along with the following username %s", user_create.SAMAccountName, user_create.BaseOU, user_create.Mail)
+
+	if err := user_create.Validate(); err != nil {
+		return fmt.Errorf("createUser - %s", err)
+	}
+
+	current := time.Now()
+	var fullName = strings.TrimSpace(fmt.Sprintf("%s %s", user_create.GivenName, user_create.Surname))
+	log.Infof("the fullname is [%s]", fullName)
 
-	tmp, err := s.getUser(user_create.name, user_create.baseOU)
+	rendered, err := s.userTemplate(user_create.BaseOU).render(UserTemplateData{
+		ADUserRequest: user_create,
+		Name:          user_create.SAMAccountName,
+		FullName:      fullName,
+		Domain:        s.client.client.domain,
+		Date:          current.Format("20060102"),
+		Now:           current,
+	})
+	if err != nil {
+		return fmt.Errorf("createUser - failed to render user template: %s", err)
+	}
+
+	// explicit request values win over the template
+	if user_create.CN != "" {
+		rendered.cn = user_create.CN
+	}
+	if user_create.UserPrincipalName != "" {
+		rendered.upn = user_create.UserPrincipalName
+	}
+	if user_create.DisplayName != "" {
+		rendered.displayName = user_create.DisplayName
+	}
+	if user_create.Description != "" {
+		rendered.description = user_create.Description
+	}
+
+	if rendered.cn == "" {
+		return fmt.Errorf("createUser - user template rendered an empty cn for %s", user_create.SAMAccountName)
+	}
+
+	if len([]rune(rendered.cn)) > maxCNLength {
+		return fmt.Errorf("createUser - cn %q is longer than %d characters", rendered.cn, maxCNLength)
+	}
+
+	if rendered.upn != "" {
+		if err := s.validateUPNSuffix(rendered.upn); err != nil {
+			return fmt.Errorf("createUser - %s", err)
+		}
+	}
+
+	tmp, err := s.getUser(rendered.cn, user_create.BaseOU)
 	if err != nil {
 		return fmt.Errorf("createUser - talking to active directory failed: %s", err)
 	}
 	// there is already a user object with the same name
 	if tmp != nil {
-		if tmp.name == user_create.name && tmp.dn == fmt.Sprintf("cn=%s,%s", user_create.name, user_create.baseOU) {
-			log.Infof("User object %s already exists, updating description", user_create.name)
+		return fmt.Errorf("createUser - User object %s already exists under this base ou %s", rendered.cn, user_create.BaseOU)
+	}
 
-		}
+	finalControl := user_create.UserAccountControl
+	if finalControl == 0 {
+		finalControl = UACNormalAccount
+	}
 
-		return fmt.Errorf("createUser - User object %s already exists under this base ou %s", user_create.name, user_create.baseOU)
+	log.Infof("Printing the prinicipalName %s", rendered.upn)
+	attributes := make(map[string][]string)
+	for key, value := range rendered.attributes {
+		attributes[key] = value
+	}
+	for key, value := range user_create.Attributes {
+		attributes[key] = value
+	}
+	for key, value := range user_create.directAttributes() {
+		if value != "" {
+			attributes[key] = []string{value}
+		}
+	}
+	// created disabled until the password is set
+	attributes["userAccountControl"] = []string{fmt.Sprintf("%d", finalControl.Set(UACAccountDisable))}
+	attributes["accountExpires"] = []string{fmt.Sprintf("%d", helper.TimeToFileTime(user_create.AccountExpires))}
+
+	optional := map[string]string{
+		"userPrincipalName": rendered.upn,
+		"displayName":       rendered.displayName,
+		"description":       rendered.description,
+		"homeDirectory":     rendered.homeDirectory,
+		"homeDrive":         rendered.homeDrive,
+	}
+	for key, value := range optional {
+		if value != "" {
+			attributes[key] = []string{value}
+		}
 	}
 
-	current := time.Now()
-	var cd = current.Format("20060102")
-	var fullName = fmt.Sprintf("%s %s", user_create.given_name, user_create.sn)
-	log.Infof("the fullname is [%s]", fullName)
-	var description = fmt.Sprintf("%s,%s/%s/%s//%s,%s%s,%s%s,%s", user_create.name, "897", "C", user_create.cdir, fullName, "CD=", cd, "RI=", "OPAASAUTO", "#CUST#")
+	var password = user_create.Password
+	if password != "" {
+		// the user does not exist yet, so only the domain policy can be checked
+		if policy, err := s.getDomainPasswordPolicy(); err != nil {
+			log.Warnf("Skipping local password policy check: %s", err)
+		} else if err := helper.ValidatePassword(policy, password, user_create.SAMAccountName, rendered.displayName); err != nil {
+			return fmt.Errorf("createUser - %s", err)
+		}
+	}
 
-	var principalName = fmt.Sprintf("%s@%s", user_create.name, s.client.client.domain)
-	log.Infof("Printing the prinicipalName %s", principalName)
-	attributes := make(map[string][]string)
-	attributes["sAMAccountName"] = []string{user_create.name}
-	attributes["userPrincipalName"] = []string{principalName} // FirstName+LastName @imzcloud-- Must be unique
-	attributes["name"] = []string{fullName}
-	attributes["givenName"] = []string{user_create.given_name}
-	attributes["displayName"] = []string{fullName}
-	attributes["sn"] = []string{user_create.sn} // This is the last name
-	attributes["description"] = []string{description}
-	attributes["userAccountControl"] = []string{fmt.Sprintf("%d", 0x0202)}
-	attributes["mail"] = []string{user_create.email}
-	attributes["accountExpires"] = []string{fmt.Sprintf("%d", 0x00000000)}
-
-	var password = user_create.password
-	ust := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
-	encoded, err := ust.NewEncoder().String(fmt.Sprintf("%q", password))
+	encoded, err := helper.EncodePassword(password)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("createUser - %s", err)
+	}
+	var usercn = "CN=" + helper.EscapeDN(rendered.cn) + "," + user_create.BaseOU
+
+	// steps run after the add, any failure deletes the user again
+	var steps []createStep
+
+	// active directory only accepts unicodePwd over an encrypted connection, in
+	// that case the password and final flags go into the add itself
+	if s.client.isEncrypted() && password != "" {
+		log.Info("Connection is encrypted, setting password and flags with the add")
+		attributes["unicodePwd"] = []string{encoded}
+		attributes["userAccountControl"] = []string{fmt.Sprintf("%d", finalControl)}
+	} else {
+		if password != "" {
+			steps = append(steps, createStep{"set password", func() error {
+				req := ldap.NewModifyRequest(usercn, nil)
+				req.Replace("unicodePwd", []string{encoded})
+				return s.client.client.conn.Modify(req)
+			}})
+		}
+		steps = append(steps, createStep{"set userAccountControl", func() error {
+			req := ldap.NewModifyRequest(usercn, nil)
+			req.Replace("userAccountControl", []string{fmt.Sprintf("%d", finalControl)})
+			return s.client.client.conn.Modify(req)
+		}})
 	}
-	var usercn = "CN=" + user_create.name + "," + user_create.baseOU
+
+	steps = append(steps, createStep{"set posix attributes", func() error {
+		if s.client.ADPosix == nil {
+			return fmt.Errorf("the client has no posix service, create it with NewClient")
+		}
+		return s.client.ADPosix.setUserPosix(usercn, user_create.Posix)
+	}})
+
 	log.Infof("Creating the user with the following cn %s", usercn)
-	err = s.client.ADObject.createObject(fmt.Sprintf("CN=%s,%s", user_create.name, user_create.baseOU), []string{"organizationalPerson", "person", "top", "user"}, attributes)
+	err = s.client.ADObject.createObject(usercn, []string{"organizationalPerson", "person", "top", "user"}, attributes)
 	if err != nil {
-		return fmt.Errorf("create User - Failed to create the user: %s", err)
+		return fmt.Errorf("createUser - Failed to create the user: %s", err)
 	}
 	log.Infof("Successfully Created the User with the cn [%s]", usercn)
-	pwdencodereq := ldap.NewModifyRequest(usercn, nil)
-	pwdencodereq.Replace("unicodePwd", []string{encoded})
 
-	if err := s.client.client.conn.Modify(pwdencodereq); err != nil {
-		return fmt.Errorf("failed to execute the modify password request", pwdencodereq, err)
+	for _, step := range steps {
+		log.Infof("createUser - %s on %s", step.name, usercn)
+		if err := step.run(); err != nil {
+			return s.rollbackUser(usercn, fmt.Errorf("failed to %s: %s", step.name, err))
+		}
 	}
 
-	userControlReq := ldap.NewModifyRequest(usercn, nil)
-	userControlReq.Replace("userAccountControl", []string{fmt.Sprintf("%d", 0x0200)})
-	if err := s.client.client.conn.Modify(userControlReq); err != nil {
-		log.Fatal("error Setting the user control", userControlReq, err)
+	return nil
+}
+
+// a step of the user creation that runs after the object was added
+type createStep struct {
+	name string
+	run  func() error
+}
+
+// deletes a partially provisioned user, returning the error that caused the rollback
+func (s *ADUserServiceOp) rollbackUser(dn string, cause error) error {
+	log.Warnf("createUser - %s, rolling back creation of %s", cause, dn)
+
+	if err := s.client.ADObject.deleteObject(dn); err != nil {
+		return fmt.Errorf("createUser - %s; rollback failed, %s is left half provisioned: %s", cause, dn, err)
 	}
 
-	userdata, err := s.getUser(user_create.name, user_create.baseOU)
+	return fmt.Errorf("createUser - %s; user %s was rolled back", cause, dn)
+}
+
+// returns the upn suffixes accepted by the forest: the dns names of all its
+// domains plus the alternative suffixes configured on the partitions container
+func (s *ADUserServiceOp) getUPNSuffixes() ([]string, error) {
+	rootDSE, err := s.client.ADObject.getObject("", []string{"configurationNamingContext"})
 	if err != nil {
-		return fmt.Errorf("createUser - talking to active directory failed: %s", err)
+		return nil, fmt.Errorf("getUPNSuffixes - failed to read rootDSE: %s", err)
 	}
 
-	//update_sid := strings.ReplaceAll(userdata.sid,"\\x","")
-	log.Infof("Printing the dn [ %s] and the sid %x", userdata.dn, userdata.sid)
+	if rootDSE == nil || rootDSE.value("configurationNamingContext") == "" {
+		return nil, fmt.Errorf("getUPNSuffixes - rootDSE does not expose the configuration naming context")
+	}
 
-	data := base64.StdEncoding.EncodeToString([]byte(userdata.sid))
+	partitions := "CN=Partitions," + rootDSE.value("configurationNamingContext")
+	suffixes := []string{}
 
-	log.Infof("Spitting out the base64 stuff %s", data)
-	sid, rid := helper.Siddecode(data)
-	log.Infof("Printing the sid %s", sid)
-	log.Infof("The unique id that will be generated is [%d]", rid+1000)
-	var generatedNumber = rid + 1000
-	uidnumberreq := ldap.NewModifyRequest(usercn, nil)
-	uidnumberreq.Replace("uidNumber", []string{strconv.Itoa(generatedNumber)})
+	container, err := s.client.ADObject.getObject(partitions, []string{"uPNSuffixes"})
+	if err != nil {
+		return nil, fmt.Errorf("getUPNSuffixes - failed to read %s: %s", partitions, err)
+	}
+	if container != nil {
+		suffixes = append(suffixes, container.values("uPNSuffixes")...)
+	}
 
-	if err := s.client.client.conn.Modify(uidnumberreq); err != nil {
-		log.Fatal("unable to update the userid that was just created", uidnumberreq, err)
+	// crossRefs of the forest's domains (systemFlags FLAG_CR_NTDS_DOMAIN)
+	domains, err := s.client.ADObject.searchObject("(&(objectClass=crossRef)(systemFlags:1.2.840.113556.1.4.803:=2))", partitions, []string{"dnsRoot"})
+	if err != nil {
+		return nil, fmt.Errorf("getUPNSuffixes - failed to list domains of the forest: %s", err)
+	}
+	for _, domain := range domains {
+		suffixes = append(suffixes, domain.values("dnsRoot")...)
 	}
 
-	return err
+	return suffixes, nil
 }
 
-// moves an existing ou object to a new ou
+// checks that the suffix of upn is accepted by the forest
+func (s *ADUserServiceOp) validateUPNSuffix(upn string) error {
+	i := strings.LastIndex(upn, "@")
+	if i <= 0 || i == len(upn)-1 {
+		return fmt.Errorf("userPrincipalName %q must have the form name@suffix", upn)
+	}
+	suffix := upn[i+1:]
+
+	suffixes, err := s.getUPNSuffixes()
+	if err != nil {
+		return err
+	}
+
+	for _, allowed := range suffixes {
+		if strings.EqualFold(allowed, suffix) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("upn suffix %q is not allowed by the forest (allowed: %s)", suffix, strings.Join(suffixes, ", "))
+}
+
+// moves an existing user object to a new ou
 func (s *ADUserServiceOp) moveUser(cn, baseOU, newOU string) error {
-	log.Infof("Moving ou object %s from %s to %s.", cn, baseOU, newOU)
+	log.Infof("Moving user object %s from %s to %s.", cn, baseOU, newOU)
 
-	tmp, err := s.getUser(cn, baseOU)
+	tmp, err := s.getUser(cn, baseOU, "cn")
 	if err != nil {
-		return fmt.Errorf("moveOU - talking to active directory failed: %s", err)
+		return fmt.Errorf("moveUser - talking to active directory failed: %s", err)
 	}
 
+	// a repeated move finds the user in the target ou
 	if tmp == nil {
-		return fmt.Errorf("moveOU - ou object %s does not exists under %s: %s", cn, baseOU, err)
+		if moved, err := s.getUser(cn, newOU, "cn"); err == nil && moved != nil {
+			log.Infof("User object is already under the target ou")
+			return nil
+		}
+		return fmt.Errorf("moveUser - user object %s does not exist under %s", cn, baseOU)
 	}
 
-	// ou object is already in the target OU, nothing to do
-	if tmp.dn == fmt.Sprintf("ou=%s,%s", cn, newOU) {
-		log.Infof("OU object is already under the target ou")
-		return nil
+	rdn, parent, err := splitDN(tmp.dn)
+	if err != nil {
+		return fmt.Errorf("moveUser - %s", err)
 	}
 
-	// specific uid of the ou
-	UID := fmt.Sprintf("ou=%s", cn)
+	// user object is already in the target OU, nothing to do
+	if equalDN(parent, newOU) {
+		log.Infof("User object is already under the target ou")
+		return nil
+	}
 
-	// move ou object to new ou
-	req := ldap.NewModifyDNRequest(fmt.Sprintf("ou=%s,%s", cn, baseOU), UID, true, newOU)
+	req := ldap.NewModifyDNRequest(tmp.dn, rdn, true, newOU)
 	if err := s.client.client.conn.ModifyDN(req); err != nil {
-		return fmt.Errorf("moveOU - failed to move ou: %s", err)
+		return fmt.Errorf("moveUser - failed to move user %s: %s", tmp.dn, err)
 	}
 
-	log.Infof("OU moved.")
+	log.Infof("User moved.")
 	return nil
 }
 
-//
+// UserRename describes the new names of a user, empty fields are left unchanged
+type UserRename struct {
+	CN string
+
+	// when empty, displayName follows the cn if it was equal to the old cn
+	DisplayName string
+
+	SAMAccountName    string
+	UserPrincipalName string
+}
 
-// updates the name of an existing user object
-func (s *ADUserServiceOp) updateUserName(name, baseOU, newName string) error {
-	log.Infof("Updating name of user %s under %s.", name, baseOU)
+// renames an existing user object, updating cn and name through the rdn and
+// displayName, sAMAccountName and userPrincipalName as requested
+func (s *ADUserServiceOp) renameUser(cn, baseOU string, rename UserRename) error {
+	log.Infof("Renaming user %s under %s.", cn, baseOU)
 
-	tmp, err := s.getUser(name, baseOU)
+	tmp, err := s.getUser(cn, baseOU, "cn", "displayName", "sAMAccountName", "userPrincipalName")
 	if err != nil {
-		return fmt.Errorf("updateOUName - talking to active directory failed: %s", err)
+		return fmt.Errorf("renameUser - talking to active directory failed: %s", err)
+	}
+
+	// a repeated rename finds the user under its new cn
+	if tmp == nil && rename.CN != "" {
+		tmp, err = s.getUser(rename.CN, baseOU, "cn", "displayName", "sAMAccountName", "userPrincipalName")
+		if err != nil {
+			return fmt.Errorf("renameUser - talking to active directory failed: %s", err)
+		}
 	}
 
 	if tmp == nil {
-		return fmt.Errorf("updateOUName - ou object %s does not exists under %s: %s", name, baseOU, err)
+		return fmt.Errorf("renameUser - user object %s does not exist under %s", cn, baseOU)
 	}
 
-	// specific uid of the user
-	UID := fmt.Sprintf("ou=%s", newName)
+	if utf8.RuneCountInString(rename.CN) > maxCNLength {
+		return fmt.Errorf("renameUser - cn %q is longer than %d characters", rename.CN, maxCNLength)
+	}
 
-	// move ou object to new ou
-	req := ldap.NewModifyDNRequest(fmt.Sprintf("ou=%s,%s", name, baseOU), UID, true, "")
-	if err := s.client.client.conn.ModifyDN(req); err != nil {
-		return fmt.Errorf("updateOUName - failed to move ou: %s", err)
+	changed := make(map[string][]string)
+
+	displayName := rename.DisplayName
+	if displayName == "" && rename.CN != "" && tmp.displayName == tmp.name {
+		displayName = rename.CN
+	}
+	if displayName != "" && displayName != tmp.displayName {
+		changed["displayName"] = []string{displayName}
+	}
+
+	if rename.SAMAccountName != "" && !strings.EqualFold(rename.SAMAccountName, tmp.samAccountName) {
+		check := ADUserRequest{BaseOU: baseOU, SAMAccountName: rename.SAMAccountName}
+		if err := check.Validate(); err != nil {
+			return fmt.Errorf("renameUser - %s", err)
+		}
+
+		existing, err := s.getUserBySAMAccountName(rename.SAMAccountName, "", "cn")
+		if err != nil {
+			return fmt.Errorf("renameUser - talking to active directory failed: %s", err)
+		}
+		if existing != nil {
+			return fmt.Errorf("renameUser - sAMAccountName %s is already used by %s", rename.SAMAccountName, existing.dn)
+		}
+		changed["sAMAccountName"] = []string{rename.SAMAccountName}
+	}
+
+	if rename.UserPrincipalName != "" && !strings.EqualFold(rename.UserPrincipalName, tmp.upn) {
+		if err := s.validateUPNSuffix(rename.UserPrincipalName); err != nil {
+			return fmt.Errorf("renameUser - %s", err)
+		}
+
+		existing, err := s.getUserByUPN(rename.UserPrincipalName, "", "cn")
+		if err != nil {
+			return fmt.Errorf("renameUser - talking to active directory failed: %s", err)
+		}
+		if existing != nil {
+			return fmt.Errorf("renameUser - userPrincipalName %s is already used by %s", rename.UserPrincipalName, existing.dn)
+		}
+		changed["userPrincipalName"] = []string{rename.UserPrincipalName}
+	}
+
+	dn := tmp.dn
+	if rename.CN != "" && rename.CN != tmp.name {
+		_, parent, err := splitDN(tmp.dn)
+		if err != nil {
+			return fmt.Errorf("renameUser - %s", err)
+		}
+
+		rdn := "CN=" + helper.EscapeDN(rename.CN)
+		req := ldap.NewModifyDNRequest(tmp.dn, rdn, true, "")
+		if err := s.client.client.conn.ModifyDN(req); err != nil {
+			return fmt.Errorf("renameUser - failed to rename %s: %s", tmp.dn, err)
+		}
+
+		dn = rdn + "," + parent
+		log.Infof("User renamed to %s.", dn)
+	}
+
+	if len(changed) == 0 {
+		log.Infof("User attributes are already up to date")
+		return nil
+	}
+
+	if err := s.client.ADObject.updateObject(dn, nil, nil, changed, nil); err != nil {
+		return fmt.Errorf("renameUser - %s", err)
 	}
 
-	log.Infof("user moved.")
 	return nil
 }
 
@@ -252,20 +687,3 @@ func (s *ADUserServiceOp) deleteUser(dn string) error {
 
 	return s.client.ADObject.deleteObject(dn)
 }
-
-func (s *ADUserServiceOp) addUserToGroup(userdn, groupdn string) error {
-
-	//First look up the user from the given cn
-
-	// Then lookup the groupdn from the given string
-
-	groupmodifyReq := ldap.NewModifyRequest(groupdn, nil)
-	groupmodifyReq.Add("member", []string{userdn})
-	if err := s.client.client.conn.Modify(groupmodifyReq); err != nil {
-		log.Fatal("unable to add the newly create user to the group ", groupmodifyReq, err)
-	}
-
-	log.Infof("adding user to the group")
-
-	return nil
-}
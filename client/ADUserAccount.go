@@ -0,0 +1,42 @@
+package client
+
+import (
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+)
+
+// enables a disabled account
+func (s *ADUserServiceOp) enableUser(dn string) error {
+	log.Infof("Enabling account %s", dn)
+	return s.client.ADObject.updateAccountControl(dn, 0, UACAccountDisable)
+}
+
+// disables an account
+func (s *ADUserServiceOp) disableUser(dn string) error {
+	log.Infof("Disabling account %s", dn)
+	return s.client.ADObject.updateAccountControl(dn, UACAccountDisable, 0)
+}
+
+// unlocks an account that was locked out by bad password attempts
+func (s *ADUserServiceOp) unlockUser(dn string) error {
+	log.Infof("Unlocking account %s", dn)
+	return s.client.ADObject.updateObject(dn, nil, nil, map[string][]string{
+		"lockoutTime": {"0"},
+	}, nil)
+}
+
+// sets the time an account expires, the zero time means it never expires
+func (s *ADUserServiceOp) setAccountExpires(dn string, expires time.Time) error {
+	if expires.IsZero() {
+		log.Infof("Clearing expiry of account %s", dn)
+	} else {
+		log.Infof("Setting expiry of account %s to %s", dn, expires.UTC().Format(time.RFC3339))
+	}
+
+	return s.client.ADObject.updateObject(dn, nil, nil, map[string][]string{
+		"accountExpires": {strconv.FormatInt(helper.TimeToFileTime(expires), 10)},
+	}, nil)
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// UserAccountControl is the userAccountControl bit field of users and computers
+type UserAccountControl int
+
+// userAccountControl flags
+const (
+	UACScript                     UserAccountControl = 0x00000001
+	UACAccountDisable             UserAccountControl = 0x00000002
+	UACHomedirRequired            UserAccountControl = 0x00000008
+	UACLockout                    UserAccountControl = 0x00000010
+	UACPasswdNotRequired          UserAccountControl = 0x00000020
+	UACPasswdCantChange           UserAccountControl = 0x00000040
+	UACEncryptedTextPwdAllowed    UserAccountControl = 0x00000080
+	UACTempDuplicateAccount       UserAccountControl = 0x00000100
+	UACNormalAccount              UserAccountControl = 0x00000200
+	UACInterdomainTrustAccount    UserAccountControl = 0x00000800
+	UACWorkstationTrustAccount    UserAccountControl = 0x00001000
+	UACServerTrustAccount         UserAccountControl = 0x00002000
+	UACDontExpirePassword         UserAccountControl = 0x00010000
+	UACMNSLogonAccount            UserAccountControl = 0x00020000
+	UACSmartcardRequired          UserAccountControl = 0x00040000
+	UACTrustedForDelegation       UserAccountControl = 0x00080000
+	UACNotDelegated               UserAccountControl = 0x00100000
+	UACUseDESKeyOnly              UserAccountControl = 0x00200000
+	UACDontRequirePreauth         UserAccountControl = 0x00400000
+	UACPasswordExpired            UserAccountControl = 0x00800000
+	UACTrustedToAuthForDelegation UserAccountControl = 0x01000000
+	UACPartialSecretsAccount      UserAccountControl = 0x04000000
+)
+
+// names used by active directory for every flag, in bit order
+var uacNames = []struct {
+	flag UserAccountControl
+	name string
+}{
+	{UACScript, "SCRIPT"},
+	{UACAccountDisable, "ACCOUNTDISABLE"},
+	{UACHomedirRequired, "HOMEDIR_REQUIRED"},
+	{UACLockout, "LOCKOUT"},
+	{UACPasswdNotRequired, "PASSWD_NOTREQD"},
+	{UACPasswdCantChange, "PASSWD_CANT_CHANGE"},
+	{UACEncryptedTextPwdAllowed, "ENCRYPTED_TEXT_PWD_ALLOWED"},
+	{UACTempDuplicateAccount, "TEMP_DUPLICATE_ACCOUNT"},
+	{UACNormalAccount, "NORMAL_ACCOUNT"},
+	{UACInterdomainTrustAccount, "INTERDOMAIN_TRUST_ACCOUNT"},
+	{UACWorkstationTrustAccount, "WORKSTATION_TRUST_ACCOUNT"},
+	{UACServerTrustAccount, "SERVER_TRUST_ACCOUNT"},
+	{UACDontExpirePassword, "DONT_EXPIRE_PASSWORD"},
+	{UACMNSLogonAccount, "MNS_LOGON_ACCOUNT"},
+	{UACSmartcardRequired, "SMARTCARD_REQUIRED"},
+	{UACTrustedForDelegation, "TRUSTED_FOR_DELEGATION"},
+	{UACNotDelegated, "NOT_DELEGATED"},
+	{UACUseDESKeyOnly, "USE_DES_KEY_ONLY"},
+	{UACDontRequirePreauth, "DONT_REQ_PREAUTH"},
+	{UACPasswordExpired, "PASSWORD_EXPIRED"},
+	{UACTrustedToAuthForDelegation, "TRUSTED_TO_AUTH_FOR_DELEGATION"},
+	{UACPartialSecretsAccount, "PARTIAL_SECRETS_ACCOUNT"},
+}
+
+// Has reports whether all bits of flag are set
+func (u UserAccountControl) Has(flag UserAccountControl) bool {
+	return u&flag == flag
+}
+
+// Set returns u with the bits of flag set
+func (u UserAccountControl) Set(flag UserAccountControl) UserAccountControl {
+	return u | flag
+}
+
+// Clear returns u with the bits of flag cleared
+func (u UserAccountControl) Clear(flag UserAccountControl) UserAccountControl {
+	return u &^ flag
+}
+
+// String renders the flags the way active directory names them, e.g. ACCOUNTDISABLE|NORMAL_ACCOUNT.
+// Bits without a name are rendered as hex.
+func (u UserAccountControl) String() string {
+	if u == 0 {
+		return "0"
+	}
+
+	var names []string
+	rest := u
+	for _, n := range uacNames {
+		if u.Has(n.flag) {
+			names = append(names, n.name)
+			rest = rest.Clear(n.flag)
+		}
+	}
+
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("0x%x", int(rest)))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// AccountState is the state active directory computes for an account in
+// msDS-User-Account-Control-Computed. The LOCKOUT and PASSWORD_EXPIRED bits
+// of userAccountControl itself are never maintained by the domain controller.
+type AccountState struct {
+	LockedOut       bool
+	PasswordExpired bool
+}
+
+// decodes msDS-User-Account-Control-Computed
+func decodeComputedUAC(computed UserAccountControl) AccountState {
+	return AccountState{
+		LockedOut:       computed.Has(UACLockout),
+		PasswordExpired: computed.Has(UACPasswordExpired),
+	}
+}
+
+// sets and clears userAccountControl flags of a user or computer account,
+// leaving all other flags untouched. The write is conditional on the value
+// that was read, a concurrent change returns a ConflictError.
+func (s *ADObjectServiceOp) updateAccountControl(dn string, set, clear UserAccountControl) error {
+	obj, err := s.getObject(dn, []string{"userAccountControl", "uSNChanged"})
+	if err != nil {
+		return fmt.Errorf("updateAccountControl - talking to active directory failed: %s", err)
+	}
+
+	if obj == nil {
+		return fmt.Errorf("updateAccountControl - account %s does not exist", dn)
+	}
+
+	current, err := strconv.Atoi(obj.value("userAccountControl"))
+	if err != nil {
+		return fmt.Errorf("updateAccountControl - invalid userAccountControl on %s: %s", dn, err)
+	}
+
+	uac := UserAccountControl(current)
+	updated := uac.Set(set).Clear(clear)
+	if updated == uac {
+		log.Info("userAccountControl is already up to date")
+		return nil
+	}
+
+	return s.updateAttributesIfUnchanged(obj, map[string][]string{
+		"userAccountControl": {strconv.Itoa(int(updated))},
+	})
+}
@@ -0,0 +1,78 @@
+package client
+
+import "testing"
+
+func TestUserAccountControlString(t *testing.T) {
+	tests := []struct {
+		uac  UserAccountControl
+		want string
+	}{
+		{0, "0"},
+		{512, "NORMAL_ACCOUNT"},
+		{514, "ACCOUNTDISABLE|NORMAL_ACCOUNT"},
+		{66048, "NORMAL_ACCOUNT|DONT_EXPIRE_PASSWORD"},
+		{4096, "WORKSTATION_TRUST_ACCOUNT"},
+		{532480, "SERVER_TRUST_ACCOUNT|TRUSTED_FOR_DELEGATION"},
+		{83890176, "WORKSTATION_TRUST_ACCOUNT|TRUSTED_TO_AUTH_FOR_DELEGATION|PARTIAL_SECRETS_ACCOUNT"},
+		{0x200 | 0x4, "NORMAL_ACCOUNT|0x4"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.uac.String(); got != tt.want {
+			t.Errorf("UserAccountControl(%d).String() = %q, want %q", int(tt.uac), got, tt.want)
+		}
+	}
+}
+
+func TestUserAccountControlFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		uac        UserAccountControl
+		set, clear UserAccountControl
+		want       UserAccountControl
+	}{
+		{"disable", 512, UACAccountDisable, 0, 514},
+		{"enable", 514, 0, UACAccountDisable, 512},
+		{"already disabled", 514, UACAccountDisable, 0, 514},
+		{"keeps unrelated bits", 66048 | 0x100000, UACAccountDisable, UACDontExpirePassword, 512 | 0x100000 | 2},
+		{"set and clear same bit", 512, UACAccountDisable, UACAccountDisable, 512},
+		{"unnamed bits survive", 512 | 0x4, UACSmartcardRequired, UACNormalAccount, 0x4 | 0x40000},
+	}
+
+	for _, tt := range tests {
+		got := tt.uac.Set(tt.set).Clear(tt.clear)
+		if got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+		if tt.set != tt.clear && tt.set != 0 && !got.Has(tt.set) {
+			t.Errorf("%s: %s does not have %s", tt.name, got, tt.set)
+		}
+		if tt.clear != 0 && got.Has(tt.clear) {
+			t.Errorf("%s: %s still has %s", tt.name, got, tt.clear)
+		}
+	}
+
+	if UserAccountControl(512).Has(UACNormalAccount | UACAccountDisable) {
+		t.Error("Has must require every bit of the flag")
+	}
+}
+
+func TestDecodeComputedUAC(t *testing.T) {
+	tests := []struct {
+		computed UserAccountControl
+		want     AccountState
+	}{
+		{0, AccountState{}},
+		{UACLockout, AccountState{LockedOut: true}},
+		{UACPasswordExpired, AccountState{PasswordExpired: true}},
+		{UACLockout | UACPasswordExpired, AccountState{LockedOut: true, PasswordExpired: true}},
+		// the computed attribute carries no other flags, stray ones are ignored
+		{UACAccountDisable | UACNormalAccount, AccountState{}},
+	}
+
+	for _, tt := range tests {
+		if got := decodeComputedUAC(tt.computed); got != tt.want {
+			t.Errorf("decodeComputedUAC(%s) = %+v, want %+v", tt.computed, got, tt.want)
+		}
+	}
+}
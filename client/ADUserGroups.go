@@ -0,0 +1,170 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+	"gopkg.in/ldap.v3"
+)
+
+// returns the dns of all groups a user is effectively in: direct and nested
+// memberships and the primary group with the groups it is nested in
+func (s *ADUserServiceOp) effectiveGroups(dn string) ([]string, error) {
+	log.Infof("Expanding nested groups of %s", dn)
+
+	user, err := s.client.ADObject.getObject(dn, []string{"memberOf", "objectSid", "primaryGroupID"})
+	if err != nil {
+		return nil, fmt.Errorf("effectiveGroups - talking to active directory failed: %s", err)
+	}
+
+	if user == nil {
+		return nil, fmt.Errorf("effectiveGroups - user %s does not exist", dn)
+	}
+
+	primary, err := s.client.primaryGroupDN(user.value("objectSid"), user.value("primaryGroupID"))
+	if err != nil {
+		return nil, fmt.Errorf("effectiveGroups - failed to resolve primary group of %s: %s", dn, err)
+	}
+
+	groups, err := s.chainGroups(user.dn, primary)
+	if err != nil {
+		log.Warnf("In-chain search failed, expanding groups of %s recursively: %s", dn, err)
+
+		if groups, err = s.recursiveGroups(user, primary); err != nil {
+			return nil, fmt.Errorf("effectiveGroups - %s", err)
+		}
+	}
+
+	return groups, nil
+}
+
+// finds all groups of a user and its primary group with one in-chain search
+func (s *ADUserServiceOp) chainGroups(dn, primary string) ([]string, error) {
+	filter := fmt.Sprintf("(member:%s:=%s)", inChainMatchingRule, ldap.EscapeFilter(dn))
+	if primary != "" {
+		filter = fmt.Sprintf("(|%s(member:%s:=%s))", filter, inChainMatchingRule, ldap.EscapeFilter(primary))
+	}
+
+	ret, err := s.client.ADObject.searchObjectPaged(fmt.Sprintf("(&(objectClass=group)%s)", filter), s.client.getDomainDN(), []string{"distinguishedName"})
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	if primary != "" {
+		groups = append(groups, primary)
+	}
+
+	dns := make([]string, len(ret))
+	for i, obj := range ret {
+		dns[i] = obj.dn
+	}
+	groups = appendUniqueDNs(groups, dns...)
+
+	return groups, nil
+}
+
+// follows memberOf group by group, visited groups are skipped so membership
+// cycles terminate
+func (s *ADUserServiceOp) recursiveGroups(user *ADObject, primary string) ([]string, error) {
+	var groups []string
+	visited := make(map[string]bool)
+
+	queue := append([]string(nil), user.values("memberOf")...)
+	if primary != "" {
+		queue = append(queue, primary)
+	}
+
+	for len(queue) > 0 {
+		dn := queue[0]
+		queue = queue[1:]
+
+		if visited[strings.ToLower(dn)] {
+			continue
+		}
+		visited[strings.ToLower(dn)] = true
+		groups = append(groups, dn)
+
+		group, err := s.client.ADObject.getObject(dn, []string{"memberOf"})
+		if err != nil {
+			return nil, err
+		}
+
+		// groups in other domains can not be followed
+		if group == nil {
+			continue
+		}
+
+		queue = append(queue, group.values("memberOf")...)
+	}
+
+	return groups, nil
+}
+
+// TokenGroup is a group sid from the token of a user, DN and Name are only
+// set when the sid was resolved
+type TokenGroup struct {
+	SID  helper.SID
+	DN   string
+	Name string
+}
+
+// returns the security groups active directory puts into the logon token of
+// a user, read from the constructed tokenGroups attribute. With resolve the
+// sids are mapped to group dns and names in a single search, sids without a
+// group in this domain are returned unresolved.
+func (s *ADUserServiceOp) tokenGroups(dn string, resolve bool) ([]TokenGroup, error) {
+	log.Infof("Reading token groups of %s", dn)
+
+	// tokenGroups is only computed on base scope searches
+	user, err := s.client.ADObject.getObject(dn, []string{"tokenGroups"})
+	if err != nil {
+		return nil, fmt.Errorf("tokenGroups - talking to active directory failed: %s", err)
+	}
+
+	if user == nil {
+		return nil, fmt.Errorf("tokenGroups - user %s does not exist", dn)
+	}
+
+	values := user.values("tokenGroups")
+	groups := make([]TokenGroup, 0, len(values))
+	for _, v := range values {
+		sid, err := helper.DecodeSID([]byte(v))
+		if err != nil {
+			return nil, fmt.Errorf("tokenGroups - invalid sid in tokenGroups of %s: %s", dn, err)
+		}
+		groups = append(groups, TokenGroup{SID: sid})
+	}
+
+	if !resolve || len(groups) == 0 {
+		return groups, nil
+	}
+
+	var filter strings.Builder
+	for _, g := range groups {
+		fmt.Fprintf(&filter, "(objectSid=%s)", helper.EscapeBinary(g.SID.Bytes()))
+	}
+
+	ret, err := s.client.ADObject.searchObject(fmt.Sprintf("(|%s)", filter.String()), s.client.getDomainDN(), []string{"objectSid", "sAMAccountName"})
+	if err != nil {
+		return nil, fmt.Errorf("tokenGroups - failed to resolve group sids: %s", err)
+	}
+
+	resolved := make(map[string]*ADObject, len(ret))
+	for _, obj := range ret {
+		if sid, err := helper.DecodeSID([]byte(obj.value("objectSid"))); err == nil {
+			resolved[sid.String()] = obj
+		}
+	}
+
+	for i := range groups {
+		if obj, ok := resolved[groups[i].SID.String()]; ok {
+			groups[i].DN = obj.dn
+			groups[i].Name = obj.value("sAMAccountName")
+		}
+	}
+
+	return groups, nil
+}
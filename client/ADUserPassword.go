@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+	"gopkg.in/ldap.v3"
+)
+
+// pwdLastSet values with a special meaning on write
+const (
+	pwdLastSetMustChange = "0"
+	pwdLastSetNow        = "-1"
+)
+
+// administrative password reset, replaces the password without knowing the old one
+func (s *ADUserServiceOp) resetPassword(dn, password string) error {
+	log.Infof("Resetting password of %s", dn)
+
+	if !s.client.isEncrypted() {
+		return fmt.Errorf("resetPassword - active directory only accepts password changes over an encrypted connection")
+	}
+
+	if err := s.precheckPassword(dn, password); err != nil {
+		return fmt.Errorf("resetPassword - %s", err)
+	}
+
+	encoded, err := helper.EncodePassword(password)
+	if err != nil {
+		return fmt.Errorf("resetPassword - %s", err)
+	}
+
+	req := ldap.NewModifyRequest(dn, nil)
+	req.Replace("unicodePwd", []string{encoded})
+	if err := s.client.client.conn.Modify(req); err != nil {
+		return fmt.Errorf("resetPassword - failed to reset password of %s: %s", dn, passwordError(err))
+	}
+
+	log.Info("Password reset")
+	return nil
+}
+
+// user initiated password change, active directory requires the old password
+// to be deleted and the new one added in the same modify request
+func (s *ADUserServiceOp) changePassword(dn, oldPassword, newPassword string) error {
+	log.Infof("Changing password of %s", dn)
+
+	if !s.client.isEncrypted() {
+		return fmt.Errorf("changePassword - active directory only accepts password changes over an encrypted connection")
+	}
+
+	if err := s.precheckPassword(dn, newPassword); err != nil {
+		return fmt.Errorf("changePassword - %s", err)
+	}
+
+	oldEncoded, err := helper.EncodePassword(oldPassword)
+	if err != nil {
+		return fmt.Errorf("changePassword - %s", err)
+	}
+
+	newEncoded, err := helper.EncodePassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("changePassword - %s", err)
+	}
+
+	req := ldap.NewModifyRequest(dn, nil)
+	req.Delete("unicodePwd", []string{oldEncoded})
+	req.Add("unicodePwd", []string{newEncoded})
+	if err := s.client.client.conn.Modify(req); err != nil {
+		return fmt.Errorf("changePassword - failed to change password of %s: %s", dn, passwordError(err))
+	}
+
+	log.Info("Password changed")
+	return nil
+}
+
+// sets or clears "user must change password at next logon"
+func (s *ADUserServiceOp) setMustChangePassword(dn string, mustChange bool) error {
+	log.Infof("Setting must change password at next logon of %s to %t", dn, mustChange)
+
+	value := pwdLastSetNow
+	if mustChange {
+		value = pwdLastSetMustChange
+	}
+
+	return s.client.ADObject.updateObject(dn, nil, nil, map[string][]string{
+		"pwdLastSet": {value},
+	}, nil)
+}
+
+// makes the errors active directory returns for rejected passwords readable
+func passwordError(err error) error {
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultConstraintViolation):
+		return fmt.Errorf("password does not meet the password policy or the old password is wrong: %s", err)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform):
+		return fmt.Errorf("server refused the password change, the connection may not be encrypted: %s", err)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultInsufficientAccessRights):
+		return fmt.Errorf("not allowed to change the password: %s", err)
+	}
+	return err
+}
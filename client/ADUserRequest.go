@@ -0,0 +1,165 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/ldap.v3"
+)
+
+// ADUserRequest describes a user to create. CN, UserPrincipalName, DisplayName
+// and Description are optional and override the values of the user template.
+type ADUserRequest struct {
+	BaseOU string
+
+	CN                string
+	SAMAccountName    string
+	UserPrincipalName string
+	GivenName         string
+	Initials          string
+	Surname           string
+	DisplayName       string
+	Description       string
+
+	Mail            string
+	TelephoneNumber string
+	Mobile          string
+	Title           string
+	Department      string
+	Company         string
+	Manager         string // distinguished name of the manager
+	EmployeeID      string
+	CDir            string
+
+	Password string
+
+	// zero means the account never expires
+	AccountExpires time.Time
+
+	// flags the account ends up with after creation, zero means a normal enabled account
+	UserAccountControl UserAccountControl
+
+	// RFC2307 attributes, a uidNumber is allocated when none is given
+	Posix PosixAttributes
+
+	// custom attributes, explicit fields above take precedence
+	Attributes map[string][]string
+}
+
+// limits enforced by active directory
+const (
+	maxSAMAccountNameLength = 20
+	maxCNLength             = 64
+	maxDisplayNameLength    = 256
+)
+
+// characters active directory does not accept in a sAMAccountName
+const samAccountNameForbidden = `"/\[]:;|=,+*?<>`
+
+// returns what is wrong with a non empty sAMAccountName
+func samAccountNameProblems(name string) []string {
+	var problems []string
+
+	switch {
+	case utf8.RuneCountInString(name) > maxSAMAccountNameLength:
+		problems = append(problems, fmt.Sprintf("sAMAccountName %q is longer than %d characters", name, maxSAMAccountNameLength))
+	case strings.ContainsAny(name, samAccountNameForbidden):
+		problems = append(problems, fmt.Sprintf("sAMAccountName %q contains one of %s", name, samAccountNameForbidden))
+	case strings.Trim(name, ". ") == "":
+		problems = append(problems, fmt.Sprintf("sAMAccountName %q must not consist only of periods and spaces", name))
+	case strings.HasSuffix(name, "."):
+		problems = append(problems, fmt.Sprintf("sAMAccountName %q must not end with a period", name))
+	}
+
+	for _, c := range name {
+		if c < 0x20 {
+			problems = append(problems, fmt.Sprintf("sAMAccountName %q contains control characters", name))
+			break
+		}
+	}
+
+	return problems
+}
+
+// ValidationError lists everything wrong with a request
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid request: %s", strings.Join(e.Problems, "; "))
+}
+
+// Validate checks the request against the constraints active directory enforces.
+// Whether the upn suffix is allowed by the forest is checked by createUser.
+func (r *ADUserRequest) Validate() error {
+	var problems []string
+
+	if r.BaseOU == "" {
+		problems = append(problems, "base ou is required")
+	} else if _, err := ldap.ParseDN(r.BaseOU); err != nil {
+		problems = append(problems, fmt.Sprintf("base ou %q is not a valid dn", r.BaseOU))
+	}
+
+	if r.SAMAccountName == "" {
+		problems = append(problems, "sAMAccountName is required")
+	} else {
+		problems = append(problems, samAccountNameProblems(r.SAMAccountName)...)
+	}
+
+	if utf8.RuneCountInString(r.CN) > maxCNLength {
+		problems = append(problems, fmt.Sprintf("cn %q is longer than %d characters", r.CN, maxCNLength))
+	}
+
+	if utf8.RuneCountInString(r.DisplayName) > maxDisplayNameLength {
+		problems = append(problems, fmt.Sprintf("displayName is longer than %d characters", maxDisplayNameLength))
+	}
+
+	if r.UserPrincipalName != "" {
+		if i := strings.LastIndex(r.UserPrincipalName, "@"); i <= 0 || i == len(r.UserPrincipalName)-1 {
+			problems = append(problems, fmt.Sprintf("userPrincipalName %q must have the form name@suffix", r.UserPrincipalName))
+		}
+	}
+
+	if r.Mail != "" && !strings.Contains(r.Mail, "@") {
+		problems = append(problems, fmt.Sprintf("mail %q is not an email address", r.Mail))
+	}
+
+	if r.Manager != "" {
+		if _, err := ldap.ParseDN(r.Manager); err != nil {
+			problems = append(problems, fmt.Sprintf("manager %q is not a valid dn", r.Manager))
+		}
+	}
+
+	for name := range r.Attributes {
+		if name == "" {
+			problems = append(problems, "custom attributes must have a name")
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// attributes of the request which map directly to a user attribute
+func (r *ADUserRequest) directAttributes() map[string]string {
+	return map[string]string{
+		"sAMAccountName":  r.SAMAccountName,
+		"givenName":       r.GivenName,
+		"initials":        r.Initials,
+		"sn":              r.Surname,
+		"mail":            r.Mail,
+		"telephoneNumber": r.TelephoneNumber,
+		"mobile":          r.Mobile,
+		"title":           r.Title,
+		"department":      r.Department,
+		"company":         r.Company,
+		"manager":         r.Manager,
+		"employeeID":      r.EmployeeID,
+	}
+}
@@ -0,0 +1,31 @@
+package client
+
+import "testing"
+
+func TestSAMAccountNameProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"jdoe", true},
+		{"Domain Admins", true},
+		{"svc-backup_01", true},
+		{"abcdefghijklmnopqrst", true},
+		{"abcdefghijklmnopqrstu", false},
+		{"sales.", false},
+		{". .", false},
+		{"tab\tname", false},
+	}
+	for _, c := range `"/\[]:;|=,+*?<>` {
+		tests = append(tests, struct {
+			name string
+			ok   bool
+		}{"group" + string(c), false})
+	}
+
+	for _, tt := range tests {
+		if problems := samAccountNameProblems(tt.name); (len(problems) == 0) != tt.ok {
+			t.Errorf("samAccountNameProblems(%q) = %v, want ok %t", tt.name, problems, tt.ok)
+		}
+	}
+}
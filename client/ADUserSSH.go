@@ -0,0 +1,180 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/surajsub/winad-client-go/helper"
+)
+
+// attribute ssh keys are stored in unless configured otherwise
+const defaultSSHKeyAttribute = "sshPublicKey"
+
+// configures where ssh keys are stored (e.g. sshPublicKey or altSecurityIdentities)
+// and which keys are accepted, empty values keep the defaults
+func (s *ADUserServiceOp) setSSHKeyConfig(attribute string, policy *helper.SSHKeyPolicy) {
+	s.sshKeyAttribute = attribute
+	s.sshKeyPolicy = policy
+}
+
+func (s *ADUserServiceOp) sshAttribute() string {
+	if s.sshKeyAttribute == "" {
+		return defaultSSHKeyAttribute
+	}
+	return s.sshKeyAttribute
+}
+
+func (s *ADUserServiceOp) sshPolicy() *helper.SSHKeyPolicy {
+	if s.sshKeyPolicy == nil {
+		return helper.DefaultSSHKeyPolicy
+	}
+	return s.sshKeyPolicy
+}
+
+// sshKeyValue is a stored attribute value together with the key parsed from it
+type sshKeyValue struct {
+	value string
+	key   *helper.SSHPublicKey
+}
+
+// reads all values of the key attribute that parse as ssh keys. Other values,
+// like certificate mappings in altSecurityIdentities, are skipped.
+func (s *ADUserServiceOp) readSSHKeys(dn string) ([]sshKeyValue, error) {
+	attribute := s.sshAttribute()
+
+	obj, err := s.client.ADObject.getObject(dn, []string{attribute})
+	if err != nil {
+		return nil, fmt.Errorf("talking to active directory failed: %s", err)
+	}
+
+	if obj == nil {
+		return nil, fmt.Errorf("user %s does not exist", dn)
+	}
+
+	var keys []sshKeyValue
+	for _, value := range obj.values(attribute) {
+		key, err := helper.ParseSSHPublicKey(value)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, sshKeyValue{value: value, key: key})
+	}
+
+	return keys, nil
+}
+
+// returns the ssh public keys of a user
+func (s *ADUserServiceOp) listSSHKeys(dn string) ([]*helper.SSHPublicKey, error) {
+	values, err := s.readSSHKeys(dn)
+	if err != nil {
+		return nil, fmt.Errorf("listSSHKeys - %s", err)
+	}
+
+	keys := make([]*helper.SSHPublicKey, len(values))
+	for i, v := range values {
+		keys[i] = v.key
+	}
+	return keys, nil
+}
+
+// adds an ssh public key to a user, adding a key the user already has is a no-op
+func (s *ADUserServiceOp) addSSHKey(dn, key string) error {
+	parsed, err := helper.ParseSSHPublicKey(key)
+	if err != nil {
+		return fmt.Errorf("addSSHKey - %s", err)
+	}
+
+	if err := s.sshPolicy().Check(parsed); err != nil {
+		return fmt.Errorf("addSSHKey - %s", err)
+	}
+
+	fingerprint := parsed.Fingerprint()
+	log.Infof("Adding ssh key %s to %s", fingerprint, dn)
+
+	existing, err := s.readSSHKeys(dn)
+	if err != nil {
+		return fmt.Errorf("addSSHKey - %s", err)
+	}
+
+	for _, v := range existing {
+		if v.key.Fingerprint() == fingerprint {
+			log.Info("User already has this ssh key")
+			return nil
+		}
+	}
+
+	return s.client.ADObject.updateObject(dn, nil, map[string][]string{
+		s.sshAttribute(): {parsed.String()},
+	}, nil, nil)
+}
+
+// removes an ssh public key, given as fingerprint or in authorized_keys format, from a user
+func (s *ADUserServiceOp) removeSSHKey(dn, key string) error {
+	fingerprint := key
+	if !strings.HasPrefix(key, "SHA256:") {
+		parsed, err := helper.ParseSSHPublicKey(key)
+		if err != nil {
+			return fmt.Errorf("removeSSHKey - %s", err)
+		}
+		fingerprint = parsed.Fingerprint()
+	}
+
+	log.Infof("Removing ssh key %s from %s", fingerprint, dn)
+
+	existing, err := s.readSSHKeys(dn)
+	if err != nil {
+		return fmt.Errorf("removeSSHKey - %s", err)
+	}
+
+	var remove []string
+	for _, v := range existing {
+		if v.key.Fingerprint() == fingerprint {
+			remove = append(remove, v.value)
+		}
+	}
+
+	if len(remove) == 0 {
+		log.Info("User does not have this ssh key")
+		return nil
+	}
+
+	return s.client.ADObject.updateObject(dn, nil, nil, nil, map[string][]string{
+		s.sshAttribute(): remove,
+	})
+}
+
+// renders the keys of a user in the format sshd expects from an
+// AuthorizedKeysCommand. Unknown, disabled or locked out users get no keys.
+func (s *ADUserServiceOp) authorizedKeys(samAccountName string) (string, error) {
+	user, err := s.getUserBySAMAccountName(samAccountName, "", "userAccountControl", "msDS-User-Account-Control-Computed")
+	if err != nil {
+		return "", fmt.Errorf("authorizedKeys - %s", err)
+	}
+
+	if user == nil {
+		return "", nil
+	}
+
+	if user.userAccountControl.Has(UACAccountDisable) || user.state.LockedOut {
+		log.Infof("Account %s is disabled or locked out, not returning ssh keys", samAccountName)
+		return "", nil
+	}
+
+	values, err := s.readSSHKeys(user.dn)
+	if err != nil {
+		return "", fmt.Errorf("authorizedKeys - %s", err)
+	}
+
+	var sb strings.Builder
+	for _, v := range values {
+		if err := s.sshPolicy().Check(v.key); err != nil {
+			log.Warnf("Skipping ssh key %s of %s: %s", v.key.Fingerprint(), samAccountName, err)
+			continue
+		}
+		sb.WriteString(v.key.String())
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
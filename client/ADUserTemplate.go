@@ -0,0 +1,211 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// UserTemplate describes how createUser derives the attributes of a new user.
+// Every field is a text/template rendered against UserTemplateData, empty
+// templates leave the attribute unset.
+type UserTemplate struct {
+	CN            string
+	UPN           string
+	DisplayName   string
+	Description   string
+	HomeDirectory string
+	HomeDrive     string
+
+	// extra attributes, every value is a template
+	Attributes map[string][]string
+}
+
+// UserTemplateData is what user templates are rendered against, all request
+// fields are available next to the computed ones
+type UserTemplateData struct {
+	ADUserRequest
+
+	// the sAMAccountName
+	Name     string
+	FullName string
+	Domain   string
+
+	// creation date formatted as 20060102
+	Date string
+	Now  time.Time
+}
+
+// DefaultUserTemplate is used for base ous without a registered template
+var DefaultUserTemplate = &UserTemplate{
+	CN:          "{{.Name}}",
+	UPN:         "{{.Name}}@{{.Domain}}",
+	DisplayName: "{{.FullName}}",
+}
+
+// LegacyUserTemplate reproduces the provisioning conventions createUser used
+// to hard-code, register it with setUserTemplate for the ous that still need them
+var LegacyUserTemplate = &UserTemplate{
+	CN:          "{{.Name}}",
+	UPN:         "{{.Name}}@{{.Domain}}",
+	DisplayName: "{{.FullName}}",
+	Description: "{{.Name}},897/C/{{.CDir}}//{{.FullName}},CD={{.Date}},RI=OPAASAUTO,#CUST#",
+}
+
+var userTemplateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+	"truncate": func(n int, s string) string {
+		if r := []rune(s); len(r) > n {
+			return string(r[:n])
+		}
+		return s
+	},
+}
+
+// renderedUser holds the values of a user template after rendering
+type renderedUser struct {
+	cn            string
+	upn           string
+	displayName   string
+	description   string
+	homeDirectory string
+	homeDrive     string
+	attributes    map[string][]string
+}
+
+// registers the template used for users created under baseOU and all ous below it
+func (s *ADUserServiceOp) setUserTemplate(baseOU string, tmpl *UserTemplate) error {
+	log.Infof("Setting user template for %s", baseOU)
+
+	if err := tmpl.validate(); err != nil {
+		return fmt.Errorf("setUserTemplate - invalid template for %s: %s", baseOU, err)
+	}
+
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+
+	if s.templates == nil {
+		s.templates = make(map[string]*UserTemplate)
+	}
+	s.templates[strings.ToLower(baseOU)] = tmpl
+	return nil
+}
+
+// returns the template of the closest base ou above dn
+func (s *ADUserServiceOp) userTemplate(dn string) *UserTemplate {
+	dn = strings.ToLower(dn)
+
+	s.templatesMu.RLock()
+	defer s.templatesMu.RUnlock()
+
+	var match string
+	tmpl := DefaultUserTemplate
+	for ou, t := range s.templates {
+		if (dn == ou || strings.HasSuffix(dn, ","+ou)) && len(ou) > len(match) {
+			match = ou
+			tmpl = t
+		}
+	}
+
+	return tmpl
+}
+
+// checks that all templates of t parse
+func (t *UserTemplate) validate() error {
+	texts := map[string]string{
+		"cn":            t.CN,
+		"upn":           t.UPN,
+		"displayName":   t.DisplayName,
+		"description":   t.Description,
+		"homeDirectory": t.HomeDirectory,
+		"homeDrive":     t.HomeDrive,
+	}
+	for name, values := range t.Attributes {
+		for i, v := range values {
+			texts[fmt.Sprintf("%s[%d]", name, i)] = v
+		}
+	}
+
+	for name, text := range texts {
+		if _, err := parseTemplate(name, text); err != nil {
+			return err
+		}
+	}
+
+	if t.CN == "" {
+		return fmt.Errorf("cn template must not be empty")
+	}
+
+	return nil
+}
+
+// renders all templates of t
+func (t *UserTemplate) render(data UserTemplateData) (*renderedUser, error) {
+	var err error
+	r := &renderedUser{attributes: make(map[string][]string)}
+
+	fields := []struct {
+		name string
+		text string
+		out  *string
+	}{
+		{"cn", t.CN, &r.cn},
+		{"upn", t.UPN, &r.upn},
+		{"displayName", t.DisplayName, &r.displayName},
+		{"description", t.Description, &r.description},
+		{"homeDirectory", t.HomeDirectory, &r.homeDirectory},
+		{"homeDrive", t.HomeDrive, &r.homeDrive},
+	}
+
+	for _, f := range fields {
+		if *f.out, err = renderTemplate(f.name, f.text, data); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, values := range t.Attributes {
+		for i, v := range values {
+			out, err := renderTemplate(fmt.Sprintf("%s[%d]", name, i), v, data)
+			if err != nil {
+				return nil, err
+			}
+			if out != "" {
+				r.attributes[name] = append(r.attributes[name], out)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+func renderTemplate(name, text string, data UserTemplateData) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+
+	tmpl, err := parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %s", name, err)
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(userTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s template: %s", name, err)
+	}
+	return tmpl, nil
+}
@@ -2,7 +2,14 @@ package helper
 
 import (
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/unicode"
 	"gopkg.in/ldap.v3"
 )
 
@@ -71,3 +78,198 @@ func Decode(b []byte) SID {
 
 	return sid
 }
+
+// ParseSID parses the string form of a sid (S-1-5-21-...)
+func ParseSID(s string) (SID, error) {
+	var sid SID
+
+	parts := strings.Split(s, "-")
+	if len(parts) < 3 || !strings.EqualFold(parts[0], "S") {
+		return sid, fmt.Errorf("invalid sid %q", s)
+	}
+
+	revision, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return sid, fmt.Errorf("invalid sid revision in %q", s)
+	}
+
+	authority, err := strconv.ParseUint(parts[2], 10, 48)
+	if err != nil {
+		return sid, fmt.Errorf("invalid sid authority in %q", s)
+	}
+
+	sid.RevisionLevel = revision
+	sid.Authority = int(authority)
+	for _, p := range parts[3:] {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return sid, fmt.Errorf("invalid sid sub authority in %q", s)
+		}
+		sid.SubAuthorities = append(sid.SubAuthorities, int(v))
+	}
+	sid.SubAuthorityCount = len(sid.SubAuthorities)
+
+	return sid, nil
+}
+
+// Bytes returns the binary form of the sid as stored in objectSid
+func (sid SID) Bytes() []byte {
+	b := make([]byte, 8+4*len(sid.SubAuthorities))
+	b[0] = byte(sid.RevisionLevel)
+	b[1] = byte(len(sid.SubAuthorities))
+
+	for i := 0; i < 6; i++ {
+		b[2+i] = byte(sid.Authority >> (8 * (5 - i)))
+	}
+
+	for i, v := range sid.SubAuthorities {
+		binary.LittleEndian.PutUint32(b[8+4*i:], uint32(v))
+	}
+
+	return b
+}
+
+// GUID is the binary form of an objectGUID as stored in active directory
+type GUID [16]byte
+
+// DecodeGUID reads a binary objectGUID value
+func DecodeGUID(b []byte) (GUID, error) {
+	var guid GUID
+	if len(b) != 16 {
+		return guid, fmt.Errorf("invalid guid length %d", len(b))
+	}
+
+	copy(guid[:], b)
+	return guid, nil
+}
+
+// ParseGUID parses the string form of a guid (xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx)
+func ParseGUID(s string) (GUID, error) {
+	var guid GUID
+
+	s = strings.Trim(s, "{}")
+	raw, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || len(raw) != 16 || len(s) != 36 {
+		return guid, fmt.Errorf("invalid guid %q", s)
+	}
+
+	// the first three groups are stored little endian
+	guid[0], guid[1], guid[2], guid[3] = raw[3], raw[2], raw[1], raw[0]
+	guid[4], guid[5] = raw[5], raw[4]
+	guid[6], guid[7] = raw[7], raw[6]
+	copy(guid[8:], raw[8:])
+
+	return guid, nil
+}
+
+func (guid GUID) String() string {
+	return fmt.Sprintf("%02x%02x%02x%02x-%02x%02x-%02x%02x-%02x%02x-%02x%02x%02x%02x%02x%02x",
+		guid[3], guid[2], guid[1], guid[0],
+		guid[5], guid[4],
+		guid[7], guid[6],
+		guid[8], guid[9],
+		guid[10], guid[11], guid[12], guid[13], guid[14], guid[15])
+}
+
+// EscapeBinary escapes a binary value for use in an ldap filter
+func EscapeBinary(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		fmt.Fprintf(&sb, "\\%02x", c)
+	}
+	return sb.String()
+}
+
+// DecodeSID reads a binary objectSid value, returning an error instead of
+// panicking on truncated input
+func DecodeSID(b []byte) (SID, error) {
+	if len(b) < 8 || len(b) < 8+4*int(b[1]) {
+		return SID{}, fmt.Errorf("invalid sid length %d", len(b))
+	}
+
+	return Decode(b), nil
+}
+
+// difference between the windows epoch (1601-01-01) and the unix epoch in 100ns intervals
+const fileTimeEpochOffset = 116444736000000000
+
+// NeverExpires is the accountExpires value active directory uses for accounts that never expire
+const NeverExpires = 0x7FFFFFFFFFFFFFFF
+
+// FileTimeToTime converts a windows FILETIME (100ns intervals since 1601) to a time.
+// 0 and NeverExpires are returned as the zero time.
+func FileTimeToTime(ft int64) time.Time {
+	if ft <= 0 || ft == NeverExpires {
+		return time.Time{}
+	}
+
+	ft -= fileTimeEpochOffset
+	return time.Unix(ft/10000000, (ft%10000000)*100).UTC()
+}
+
+// TimeToFileTime converts a time to a windows FILETIME, the zero time becomes 0
+func TimeToFileTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()*10000000 + int64(t.Nanosecond()/100) + fileTimeEpochOffset
+}
+
+// ParseFileTime parses the string value of a FILETIME attribute such as pwdLastSet or accountExpires
+func ParseFileTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	ft, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid filetime %q", s)
+	}
+
+	return FileTimeToTime(ft), nil
+}
+
+// ParseGeneralizedTime parses a generalized time attribute such as whenCreated (20060102150405.0Z)
+func ParseGeneralizedTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse("20060102150405.0Z", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid generalized time %q", s)
+	}
+
+	return t, nil
+}
+
+// EscapeDN escapes a value for use as an attribute value in a distinguished name (RFC 4514)
+func EscapeDN(value string) string {
+	var sb strings.Builder
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			sb.WriteRune('\\')
+			sb.WriteRune(c)
+		case c == 0:
+			sb.WriteString(`\00`)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+// EncodePassword returns the unicodePwd value for a password: the password
+// enclosed in double quotes, encoded as UTF-16LE
+func EncodePassword(password string) (string, error) {
+	utf16 := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	encoded, err := utf16.NewEncoder().String("\"" + password + "\"")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode password: %s", err)
+	}
+	return encoded, nil
+}
@@ -0,0 +1,146 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseSID(t *testing.T) {
+	tests := []struct {
+		sid string
+		hex string
+	}{
+		{"S-1-0-0", "010100000000000000000000"},
+		{"S-1-1-0", "010100000000000100000000"},
+		{"S-1-5-32-544", "01020000000000052000000020020000"},
+		{"S-1-5-21-3623811015-3361044348-30300820-1013", "010500000000000515000000c7f7fed77c7755c8945ace01f5030000"},
+	}
+
+	for _, tt := range tests {
+		sid, err := ParseSID(tt.sid)
+		if err != nil {
+			t.Errorf("ParseSID(%q): %s", tt.sid, err)
+			continue
+		}
+
+		if got := hex.EncodeToString(sid.Bytes()); got != tt.hex {
+			t.Errorf("ParseSID(%q).Bytes() = %s, want %s", tt.sid, got, tt.hex)
+		}
+
+		if sid.String() != tt.sid {
+			t.Errorf("ParseSID(%q).String() = %s", tt.sid, sid)
+		}
+
+		b, _ := hex.DecodeString(tt.hex)
+		decoded, err := DecodeSID(b)
+		if err != nil {
+			t.Errorf("DecodeSID(%s): %s", tt.hex, err)
+			continue
+		}
+		if decoded.String() != tt.sid || !bytes.Equal(decoded.Bytes(), b) {
+			t.Errorf("DecodeSID(%s) = %s", tt.hex, decoded)
+		}
+	}
+}
+
+func TestParseSIDInvalid(t *testing.T) {
+	for _, s := range []string{"", "S-1", "X-1-5-32", "S-x-5", "S-1-5-abc", "S-1-5-4294967296"} {
+		if _, err := ParseSID(s); err == nil {
+			t.Errorf("ParseSID(%q): expected an error", s)
+		}
+	}
+}
+
+func TestSIDRID(t *testing.T) {
+	sid, _ := ParseSID("S-1-5-21-3623811015-3361044348-30300820-1013")
+	if sid.RID() != 1013 {
+		t.Errorf("RID() = %d", sid.RID())
+	}
+}
+
+func TestGUID(t *testing.T) {
+	tests := []struct {
+		guid string
+		hex  string
+	}{
+		// schemaIDGUID of the member attribute
+		{"bf9679c0-0de6-11d0-a285-00aa003049e2", "c07996bfe60dd011a28500aa003049e2"},
+		{"00112233-4455-6677-8899-aabbccddeeff", "33221100554477668899aabbccddeeff"},
+		{"00000000-0000-0000-0000-000000000000", "00000000000000000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		guid, err := ParseGUID(tt.guid)
+		if err != nil {
+			t.Errorf("ParseGUID(%q): %s", tt.guid, err)
+			continue
+		}
+
+		if got := hex.EncodeToString(guid[:]); got != tt.hex {
+			t.Errorf("ParseGUID(%q) = %s, want %s", tt.guid, got, tt.hex)
+		}
+
+		b, _ := hex.DecodeString(tt.hex)
+		decoded, err := DecodeGUID(b)
+		if err != nil {
+			t.Errorf("DecodeGUID(%s): %s", tt.hex, err)
+			continue
+		}
+		if decoded.String() != tt.guid {
+			t.Errorf("DecodeGUID(%s).String() = %s, want %s", tt.hex, decoded, tt.guid)
+		}
+	}
+}
+
+func TestParseGUIDFormats(t *testing.T) {
+	want := "bf9679c0-0de6-11d0-a285-00aa003049e2"
+	for _, s := range []string{"{bf9679c0-0de6-11d0-a285-00aa003049e2}", "BF9679C0-0DE6-11D0-A285-00AA003049E2"} {
+		guid, err := ParseGUID(s)
+		if err != nil || guid.String() != want {
+			t.Errorf("ParseGUID(%q) = %s, %v", s, guid, err)
+		}
+	}
+
+	for _, s := range []string{"", "bf9679c0", "bf9679c00de611d0a28500aa003049e2", "zf9679c0-0de6-11d0-a285-00aa003049e2"} {
+		if _, err := ParseGUID(s); err == nil {
+			t.Errorf("ParseGUID(%q): expected an error", s)
+		}
+	}
+
+	if _, err := DecodeGUID(make([]byte, 15)); err == nil {
+		t.Errorf("DecodeGUID: expected an error for 15 bytes")
+	}
+}
+
+func TestEscapeBinary(t *testing.T) {
+	tests := map[string]string{
+		"":           "",
+		"00":         `\00`,
+		"2a28295c":   `\2a\28\29\5c`,
+		"c07996bfff": `\c0\79\96\bf\ff`,
+	}
+
+	for in, want := range tests {
+		b, _ := hex.DecodeString(in)
+		if got := EscapeBinary(b); got != want {
+			t.Errorf("EscapeBinary(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestDecodeSIDInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":             "",
+		"short header":      "0105000000",
+		"missing subauths":  "010500000000000515000000",
+		"truncated subauth": "01020000000000052000000020",
+	}
+
+	for name, in := range tests {
+		b, _ := hex.DecodeString(in)
+		if _, err := DecodeSID(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
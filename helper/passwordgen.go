@@ -0,0 +1,241 @@
+package helper
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// character sets used by the password generator
+const (
+	passwordUpper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	passwordLower   = "abcdefghijklmnopqrstuvwxyz"
+	passwordDigits  = "0123456789"
+	passwordSpecial = "!#$%&*+-=?@^_"
+
+	// characters that are easily confused when read or typed
+	passwordAmbiguous = "0O1lI|"
+)
+
+const (
+	defaultPasswordLength  = 16
+	defaultPassphraseWords = 4
+	maxGenerateAttempts    = 100
+)
+
+// PasswordOptions controls the password generator
+type PasswordOptions struct {
+	// minimum length, raised to the policy minimum when that is higher
+	Length int
+
+	// leave out characters that are easily confused (0O1lI|)
+	ExcludeAmbiguous bool
+
+	// generate words joined by Separator instead of random characters
+	Passphrase bool
+	Words      int
+	Separator  string
+	Wordlist   []string
+
+	// the generated password must not contain these, as enforced by password complexity
+	AccountName string
+	DisplayName string
+}
+
+// GeneratePassword returns a crypto-random password that satisfies policy
+func GeneratePassword(policy *PasswordPolicy, opts PasswordOptions) (string, error) {
+	if policy == nil {
+		policy = &PasswordPolicy{}
+	}
+
+	length := opts.Length
+	if length == 0 {
+		length = defaultPasswordLength
+	}
+	if length < policy.MinLength {
+		length = policy.MinLength
+	}
+
+	for i := 0; i < maxGenerateAttempts; i++ {
+		var password string
+		var err error
+		if opts.Passphrase {
+			password, err = generatePassphrase(length, opts)
+		} else {
+			password, err = generateCharacters(length, opts.ExcludeAmbiguous)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// random output can still contain the account or display name
+		if ValidatePassword(policy, password, opts.AccountName, opts.DisplayName) == nil {
+			return password, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to generate a password satisfying the policy after %d attempts", maxGenerateAttempts)
+}
+
+// random characters with at least one character of every set
+func generateCharacters(length int, excludeAmbiguous bool) (string, error) {
+	sets := []string{passwordUpper, passwordLower, passwordDigits, passwordSpecial}
+	if excludeAmbiguous {
+		for i, set := range sets {
+			sets[i] = removeCharacters(set, passwordAmbiguous)
+		}
+	}
+
+	if length < len(sets) {
+		length = len(sets)
+	}
+
+	all := strings.Join(sets, "")
+	password := make([]byte, 0, length)
+	for _, set := range sets {
+		c, err := randomByte(set)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	for len(password) < length {
+		c, err := randomByte(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	if err := shuffle(password); err != nil {
+		return "", err
+	}
+
+	return string(password), nil
+}
+
+// capitalized random words followed by a digit, long enough for length.
+// ExcludeAmbiguous drops every word that contains an ambiguous character once
+// capitalized.
+func generatePassphrase(length int, opts PasswordOptions) (string, error) {
+	wordlist := opts.Wordlist
+	if len(wordlist) == 0 {
+		wordlist = passphraseWords
+	}
+
+	separator := opts.Separator
+	if separator == "" {
+		separator = "-"
+	}
+
+	if opts.ExcludeAmbiguous {
+		if strings.ContainsAny(separator, passwordAmbiguous) {
+			return "", fmt.Errorf("passphrase separator %q contains ambiguous characters", separator)
+		}
+
+		var filtered []string
+		for _, word := range wordlist {
+			if word != "" && !strings.ContainsAny(capitalize(word), passwordAmbiguous) {
+				filtered = append(filtered, word)
+			}
+		}
+		wordlist = filtered
+	}
+
+	if len(wordlist) < 2 {
+		return "", fmt.Errorf("passphrase wordlist needs at least 2 usable words")
+	}
+
+	words := opts.Words
+	if words == 0 {
+		words = defaultPassphraseWords
+	}
+
+	var parts []string
+	size := 0
+	for len(parts) < words || size < length {
+		n, err := randomInt(len(wordlist))
+		if err != nil {
+			return "", err
+		}
+		word := capitalize(wordlist[n])
+		parts = append(parts, word)
+		size += len(word) + len(separator)
+	}
+
+	digits := passwordDigits
+	if opts.ExcludeAmbiguous {
+		digits = removeCharacters(digits, passwordAmbiguous)
+	}
+	digit, err := randomByte(digits)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(parts, separator) + separator + string(digit), nil
+}
+
+func capitalize(word string) string {
+	if word == "" {
+		return word
+	}
+	return strings.ToUpper(word[:1]) + word[1:]
+}
+
+func removeCharacters(set, remove string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(remove, r) {
+			return -1
+		}
+		return r
+	}, set)
+}
+
+func randomInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read random data: %s", err)
+	}
+	return int(v.Int64()), nil
+}
+
+func randomByte(set string) (byte, error) {
+	n, err := randomInt(len(set))
+	if err != nil {
+		return 0, err
+	}
+	return set[n], nil
+}
+
+// fisher-yates shuffle using crypto/rand
+func shuffle(b []byte) error {
+	for i := len(b) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return err
+		}
+		b[i], b[j] = b[j], b[i]
+	}
+	return nil
+}
+
+// default passphrase wordlist, 256 short common words
+var passphraseWords = strings.Fields(`
+acid acorn actor adult agent alarm album alert alley amber angle ankle apple apron arena armor arrow atlas
+attic audio award bacon badge bagel baker banjo barn basil beach beard bench berry bison blade blank blaze
+bloom board bonus booth brain brass bread brick bride brook broom brush bucket bugle cabin cable camel canal
+candy canoe cargo carol cedar chalk charm chess chief chili chimp cider cigar civic clamp cliff clock cloud
+coach cobra cocoa comet coral couch cover crane crate crown cubic curve daisy dance delta denim depot diary
+dingo disco dodge donut draft dream drift drum eagle easel elbow elder ember empty epoch equal fable fairy
+falcon fancy feast fence ferry fiber field flame flask fleet flint flock flute focus forge frost fruit fudge
+gecko genie giant glade glass globe glove grape gravy grove guide habit hammer harbor haven hazel hedge heron
+hippo honey hotel husky igloo image index ivory jelly jewel joker judge juice kayak kettle kiosk koala label
+ladder lemon level lilac limbo linen llama lobby lodge lotus lunar lyric magic mango maple march melon metal
+mimic minor mocha model moose motel mural music nacho nerve noble north novel nylon oasis ocean olive omega
+onion opera orbit otter oxide paddle panda paper parka pasta pearl pecan pedal pepper piano pilot pixel plaza
+polar poppy prism pulse quail quartz quest quilt radar radio raven relay rhino ridge rival river robin rocket
+rodeo rover royal ruby saddle salad salsa satin sauna scarf scout shelf shrub sigma silk siren slate sloth
+solar spark tulip zebra
+`)
@@ -0,0 +1,106 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGeneratePasswordSatisfiesPolicy(t *testing.T) {
+	policies := []*PasswordPolicy{
+		nil,
+		{MinLength: 8, ComplexityEnabled: true},
+		{MinLength: 24, ComplexityEnabled: true},
+		{MinLength: 4},
+	}
+
+	options := []PasswordOptions{
+		{},
+		{Length: 6},
+		{Length: 32, ExcludeAmbiguous: true},
+		{AccountName: "jdoe", DisplayName: "John Doe"},
+		{Passphrase: true},
+		{Passphrase: true, Words: 6, Separator: "."},
+		{Passphrase: true, ExcludeAmbiguous: true, AccountName: "jdoe"},
+	}
+
+	for _, policy := range policies {
+		for _, opts := range options {
+			for i := 0; i < 20; i++ {
+				password, err := GeneratePassword(policy, opts)
+				if err != nil {
+					t.Fatalf("GeneratePassword(%+v, %+v): %s", policy, opts, err)
+				}
+
+				check := policy
+				if check == nil {
+					check = &PasswordPolicy{}
+				}
+				if err := ValidatePassword(check, password, opts.AccountName, opts.DisplayName); err != nil {
+					t.Errorf("GeneratePassword(%+v, %+v) = %q: %s", policy, opts, password, err)
+				}
+
+				if min := opts.Length; !opts.Passphrase && min > 0 && utf8.RuneCountInString(password) < min {
+					t.Errorf("GeneratePassword(%+v) = %q, shorter than %d", opts, password, min)
+				}
+
+				if opts.ExcludeAmbiguous && strings.ContainsAny(password, passwordAmbiguous) {
+					t.Errorf("GeneratePassword(%+v) = %q contains ambiguous characters", opts, password)
+				}
+			}
+		}
+	}
+}
+
+func TestGeneratePasswordDefaultLength(t *testing.T) {
+	password, err := GeneratePassword(nil, PasswordOptions{})
+	if err != nil {
+		t.Fatalf("GeneratePassword: %s", err)
+	}
+	if len(password) != defaultPasswordLength {
+		t.Errorf("len(%q) = %d, want %d", password, len(password), defaultPasswordLength)
+	}
+
+	password, err = GeneratePassword(&PasswordPolicy{MinLength: 30}, PasswordOptions{Length: 10})
+	if err != nil {
+		t.Fatalf("GeneratePassword: %s", err)
+	}
+	if len(password) != 30 {
+		t.Errorf("len(%q) = %d, want the policy minimum 30", password, len(password))
+	}
+}
+
+func TestGeneratePassphraseExcludeAmbiguous(t *testing.T) {
+	opts := PasswordOptions{
+		Passphrase:       true,
+		ExcludeAmbiguous: true,
+		Wordlist:         []string{"lamp", "island", "orange", "table", "river", "Oak"},
+	}
+
+	for i := 0; i < 50; i++ {
+		password, err := GeneratePassword(nil, opts)
+		if err != nil {
+			t.Fatalf("GeneratePassword: %s", err)
+		}
+		for _, word := range strings.Split(password, "-") {
+			switch word {
+			case "Island", "Orange", "Oak":
+				t.Errorf("passphrase %q uses ambiguous word %s", password, word)
+			}
+		}
+		if strings.ContainsAny(password, passwordAmbiguous) {
+			t.Errorf("passphrase %q contains ambiguous characters", password)
+		}
+	}
+
+	opts.Wordlist = []string{"island", "orange", "table"}
+	if _, err := GeneratePassword(nil, opts); err == nil {
+		t.Errorf("expected an error when fewer than 2 words remain")
+	}
+
+	opts.Wordlist = nil
+	opts.Separator = "|"
+	if _, err := GeneratePassword(nil, opts); err == nil {
+		t.Errorf("expected an error for an ambiguous separator")
+	}
+}
@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// pwdProperties flags
+const (
+	DomainPasswordComplex        = 0x01
+	DomainPasswordNoAnonChange   = 0x02
+	DomainPasswordNoClearChange  = 0x04
+	DomainLockoutAdmins          = 0x08
+	DomainPasswordStoreCleartext = 0x10
+	DomainRefusePasswordChange   = 0x20
+)
+
+// PasswordPolicy is the password policy that applies to an account, either
+// the domain policy or a fine-grained password settings object
+type PasswordPolicy struct {
+	// dn of the password settings object, empty for the domain policy
+	Source            string
+	MinLength         int
+	ComplexityEnabled bool
+	HistoryLength     int
+	MinAge            time.Duration
+	MaxAge            time.Duration
+}
+
+// PasswordPolicyError lists every rule a password breaks
+type PasswordPolicyError struct {
+	Problems []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return fmt.Sprintf("password does not meet the password policy: %s", strings.Join(e.Problems, "; "))
+}
+
+// characters windows counts as special characters for password complexity
+const passwordSpecialCharacters = "~!@#$%^&*_-+=`|\\(){}[]:;\"'<>,.?/ "
+
+// characters windows splits display names on before matching them against a password
+const displayNameDelimiters = ",.-_# \t"
+
+// ValidatePassword checks a password against the policy the way the domain
+// controller does. Password history cannot be checked without the stored
+// hashes and is left to the domain controller.
+func ValidatePassword(policy *PasswordPolicy, password, samAccountName, displayName string) error {
+	var problems []string
+
+	if n := utf8.RuneCountInString(password); n < policy.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long, got %d", policy.MinLength, n))
+	}
+
+	if policy.ComplexityEnabled {
+		if n := PasswordCategories(password); n < 3 {
+			problems = append(problems, fmt.Sprintf("must contain characters from at least 3 of uppercase, lowercase, digits, special characters and other letters, got %d", n))
+		}
+
+		lower := strings.ToLower(password)
+		if len(samAccountName) >= 3 && strings.Contains(lower, strings.ToLower(samAccountName)) {
+			problems = append(problems, "must not contain the account name")
+		}
+
+		tokens := strings.FieldsFunc(displayName, func(r rune) bool {
+			return strings.ContainsRune(displayNameDelimiters, r)
+		})
+		for _, token := range tokens {
+			if utf8.RuneCountInString(token) >= 3 && strings.Contains(lower, strings.ToLower(token)) {
+				problems = append(problems, fmt.Sprintf("must not contain %q from the display name", token))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+
+	return nil
+}
+
+// PasswordCategories returns how many of the five complexity categories a password uses
+func PasswordCategories(password string) int {
+	var upper, lower, digit, special, other bool
+
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case strings.ContainsRune(passwordSpecialCharacters, c):
+			special = true
+		case unicode.IsLetter(c):
+			other = true
+		}
+	}
+
+	n := 0
+	for _, used := range []bool{upper, lower, digit, special, other} {
+		if used {
+			n++
+		}
+	}
+	return n
+}
+
+// IntervalToDuration converts an active directory interval (negative 100ns units, as used by maxPwdAge) to a duration.
+// The "never" value is returned as 0.
+func IntervalToDuration(interval int64) time.Duration {
+	if interval == -NeverExpires-1 || interval == 0 {
+		return 0
+	}
+	if interval < 0 {
+		interval = -interval
+	}
+	return time.Duration(interval) * 100
+}
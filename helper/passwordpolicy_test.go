@@ -0,0 +1,95 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPasswordCategories(t *testing.T) {
+	tests := map[string]int{
+		"":           0,
+		"abcdef":     1,
+		"ABCdef":     2,
+		"ABCdef12":   3,
+		"ABCdef12!":  4,
+		"ABCdef12!ä": 4,
+		"ABCdef12!漢": 5,
+		"漢字漢字":       1,
+		"1234 5678":  2,
+		"Passw0rd":   3,
+		"pass word":  2,
+		"PASSWORD1!": 3,
+	}
+
+	for password, want := range tests {
+		if got := PasswordCategories(password); got != want {
+			t.Errorf("PasswordCategories(%q) = %d, want %d", password, got, want)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	complex := &PasswordPolicy{MinLength: 8, ComplexityEnabled: true}
+	simple := &PasswordPolicy{MinLength: 8}
+
+	tests := []struct {
+		name        string
+		policy      *PasswordPolicy
+		password    string
+		account     string
+		displayName string
+		problems    int
+	}{
+		{"valid", complex, "Tr0ub4dor&3", "jdoe", "John Doe", 0},
+		{"too short", complex, "Ab1!", "jdoe", "John Doe", 1},
+		{"two categories", complex, "abcdefgh12", "jdoe", "John Doe", 1},
+		{"three categories", complex, "abcdefgh12!", "jdoe", "John Doe", 0},
+		{"short and simple", complex, "abc", "jdoe", "", 2},
+		{"account name", complex, "xJDOE-2024!", "jdoe", "", 1},
+		{"short account name is ignored", complex, "xJo-2024!ab", "jo", "", 0},
+		{"display name token", complex, "Johnny-2024!", "jsmith", "John Smith", 1},
+		{"two display name tokens", complex, "john+SMITH-1", "js", "John Smith", 2},
+		{"display name split on delimiters", complex, "Xsmith#9000", "js", "Doe,Smith-Jones", 1},
+		{"short display name tokens are ignored", complex, "Al-Ed-2024!x", "aed", "Al Ed", 0},
+		{"complexity disabled", simple, "abcdefgh", "abcdefgh", "abcdefgh", 0},
+		{"complexity disabled too short", simple, "abc", "", "", 1},
+		{"length counts characters", &PasswordPolicy{MinLength: 4}, "äöüß", "", "", 0},
+	}
+
+	for _, tt := range tests {
+		err := ValidatePassword(tt.policy, tt.password, tt.account, tt.displayName)
+
+		if tt.problems == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", tt.name, err)
+			}
+			continue
+		}
+
+		perr, ok := err.(*PasswordPolicyError)
+		if !ok {
+			t.Errorf("%s: expected a PasswordPolicyError, got %v", tt.name, err)
+			continue
+		}
+
+		if len(perr.Problems) != tt.problems {
+			t.Errorf("%s: got %d problems, want %d: %s", tt.name, len(perr.Problems), tt.problems, perr)
+		}
+	}
+}
+
+func TestIntervalToDuration(t *testing.T) {
+	tests := map[int64]time.Duration{
+		0:                 0,
+		-NeverExpires - 1: 0,
+		-36288000000000:   42 * 24 * time.Hour,
+		-18000000000:      30 * time.Minute,
+		18000000000:       30 * time.Minute,
+	}
+
+	for interval, want := range tests {
+		if got := IntervalToDuration(interval); got != want {
+			t.Errorf("IntervalToDuration(%d) = %s, want %s", interval, got, want)
+		}
+	}
+}
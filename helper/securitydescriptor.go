@@ -0,0 +1,304 @@
+package helper
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// security descriptor control flags
+const (
+	SEDaclPresent  = 0x0004
+	SESelfRelative = 0x8000
+)
+
+// ace types
+const (
+	AccessAllowedACEType       = 0x00
+	AccessDeniedACEType        = 0x01
+	AccessAllowedObjectACEType = 0x05
+	AccessDeniedObjectACEType  = 0x06
+)
+
+// ace flags
+const (
+	ObjectInheritACE    = 0x01
+	ContainerInheritACE = 0x02
+	InheritOnlyACE      = 0x08
+	InheritedACE        = 0x10
+)
+
+// object ace flags
+const (
+	ACEObjectTypePresent          = 0x01
+	ACEInheritedObjectTypePresent = 0x02
+)
+
+// active directory access rights
+const (
+	ADSRightDSCreateChild  = 0x00000001
+	ADSRightDSDeleteChild  = 0x00000002
+	ADSRightDSSelf         = 0x00000008
+	ADSRightDSReadProp     = 0x00000010
+	ADSRightDSWriteProp    = 0x00000020
+	ADSRightDSDeleteTree   = 0x00000040
+	ADSRightDSControl      = 0x00000100
+	ADSRightDelete         = 0x00010000
+	ADSRightReadControl    = 0x00020000
+	ADSRightWriteDAC       = 0x00040000
+	ADSRightWriteOwner     = 0x00080000
+	ADSRightGenericAll     = 0x10000000
+	ADSRightGenericExecute = 0x20000000
+	ADSRightGenericWrite   = 0x40000000
+	ADSRightGenericRead    = 0x80000000
+)
+
+// SecurityDescriptor is a self-relative windows security descriptor as stored in nTSecurityDescriptor
+type SecurityDescriptor struct {
+	Revision byte
+	Control  uint16
+	Owner    []byte
+	Group    []byte
+	Sacl     *ACL
+	Dacl     *ACL
+}
+
+// ACL is a windows access control list
+type ACL struct {
+	Revision byte
+	Aces     []*ACE
+}
+
+// ACE is a windows access control entry. Object type fields are only used
+// by the object ace types, ace types this package does not understand are
+// kept in Raw and written back unchanged.
+type ACE struct {
+	Type                byte
+	Flags               byte
+	Mask                uint32
+	ObjectFlags         uint32
+	ObjectType          [16]byte
+	InheritedObjectType [16]byte
+	SID                 []byte
+	ApplicationData     []byte
+	Raw                 []byte
+}
+
+// DecodeSecurityDescriptor parses a self-relative security descriptor
+func DecodeSecurityDescriptor(b []byte) (*SecurityDescriptor, error) {
+	if len(b) < 20 {
+		return nil, fmt.Errorf("security descriptor too short (%d bytes)", len(b))
+	}
+
+	sd := &SecurityDescriptor{
+		Revision: b[0],
+		Control:  binary.LittleEndian.Uint16(b[2:4]),
+	}
+
+	offOwner := binary.LittleEndian.Uint32(b[4:8])
+	offGroup := binary.LittleEndian.Uint32(b[8:12])
+	offSacl := binary.LittleEndian.Uint32(b[12:16])
+	offDacl := binary.LittleEndian.Uint32(b[16:20])
+
+	var err error
+	if offOwner != 0 {
+		if sd.Owner, err = readSID(b, int(offOwner)); err != nil {
+			return nil, fmt.Errorf("owner: %s", err)
+		}
+	}
+
+	if offGroup != 0 {
+		if sd.Group, err = readSID(b, int(offGroup)); err != nil {
+			return nil, fmt.Errorf("group: %s", err)
+		}
+	}
+
+	if offSacl != 0 {
+		if sd.Sacl, err = decodeACL(b, int(offSacl)); err != nil {
+			return nil, fmt.Errorf("sacl: %s", err)
+		}
+	}
+
+	if offDacl != 0 {
+		if sd.Dacl, err = decodeACL(b, int(offDacl)); err != nil {
+			return nil, fmt.Errorf("dacl: %s", err)
+		}
+	}
+
+	return sd, nil
+}
+
+// Encode returns the self-relative binary form of the security descriptor
+func (sd *SecurityDescriptor) Encode() []byte {
+	out := make([]byte, 20)
+	out[0] = sd.Revision
+	control := sd.Control | SESelfRelative
+
+	if sd.Sacl != nil {
+		binary.LittleEndian.PutUint32(out[12:16], uint32(len(out)))
+		out = append(out, sd.Sacl.encode()...)
+	}
+
+	if sd.Dacl != nil {
+		control |= SEDaclPresent
+		binary.LittleEndian.PutUint32(out[16:20], uint32(len(out)))
+		out = append(out, sd.Dacl.encode()...)
+	}
+
+	if sd.Owner != nil {
+		binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)))
+		out = append(out, sd.Owner...)
+	}
+
+	if sd.Group != nil {
+		binary.LittleEndian.PutUint32(out[8:12], uint32(len(out)))
+		out = append(out, sd.Group...)
+	}
+
+	binary.LittleEndian.PutUint16(out[2:4], control)
+	return out
+}
+
+func readSID(b []byte, offset int) ([]byte, error) {
+	if offset+8 > len(b) {
+		return nil, fmt.Errorf("sid offset %d out of range", offset)
+	}
+
+	size := 8 + 4*int(b[offset+1])
+	if offset+size > len(b) {
+		return nil, fmt.Errorf("sid at offset %d is truncated", offset)
+	}
+
+	sid := make([]byte, size)
+	copy(sid, b[offset:offset+size])
+	return sid, nil
+}
+
+func decodeACL(b []byte, offset int) (*ACL, error) {
+	if offset+8 > len(b) {
+		return nil, fmt.Errorf("acl offset %d out of range", offset)
+	}
+
+	acl := &ACL{Revision: b[offset]}
+	size := int(binary.LittleEndian.Uint16(b[offset+2 : offset+4]))
+	count := int(binary.LittleEndian.Uint16(b[offset+4 : offset+6]))
+	if offset+size > len(b) {
+		return nil, fmt.Errorf("acl at offset %d is truncated", offset)
+	}
+
+	pos := offset + 8
+	for i := 0; i < count; i++ {
+		if pos+4 > offset+size {
+			return nil, fmt.Errorf("ace %d out of range", i)
+		}
+
+		aceSize := int(binary.LittleEndian.Uint16(b[pos+2 : pos+4]))
+		if aceSize < 4 || pos+aceSize > offset+size {
+			return nil, fmt.Errorf("ace %d has an invalid size %d", i, aceSize)
+		}
+
+		ace, err := decodeACE(b[pos : pos+aceSize])
+		if err != nil {
+			return nil, fmt.Errorf("ace %d: %s", i, err)
+		}
+
+		acl.Aces = append(acl.Aces, ace)
+		pos += aceSize
+	}
+
+	return acl, nil
+}
+
+func decodeACE(b []byte) (*ACE, error) {
+	ace := &ACE{Type: b[0], Flags: b[1]}
+
+	switch ace.Type {
+	case AccessAllowedACEType, AccessDeniedACEType:
+		if len(b) < 16 {
+			return nil, fmt.Errorf("ace too short")
+		}
+		ace.Mask = binary.LittleEndian.Uint32(b[4:8])
+		sid, err := readSID(b, 8)
+		if err != nil {
+			return nil, err
+		}
+		ace.SID = sid
+		ace.ApplicationData = append([]byte(nil), b[8+len(sid):]...)
+
+	case AccessAllowedObjectACEType, AccessDeniedObjectACEType:
+		if len(b) < 12 {
+			return nil, fmt.Errorf("object ace too short")
+		}
+		ace.Mask = binary.LittleEndian.Uint32(b[4:8])
+		ace.ObjectFlags = binary.LittleEndian.Uint32(b[8:12])
+		pos := 12
+		if ace.ObjectFlags&ACEObjectTypePresent != 0 {
+			if pos+16 > len(b) {
+				return nil, fmt.Errorf("object ace too short")
+			}
+			copy(ace.ObjectType[:], b[pos:pos+16])
+			pos += 16
+		}
+		if ace.ObjectFlags&ACEInheritedObjectTypePresent != 0 {
+			if pos+16 > len(b) {
+				return nil, fmt.Errorf("object ace too short")
+			}
+			copy(ace.InheritedObjectType[:], b[pos:pos+16])
+			pos += 16
+		}
+		sid, err := readSID(b, pos)
+		if err != nil {
+			return nil, err
+		}
+		ace.SID = sid
+		ace.ApplicationData = append([]byte(nil), b[pos+len(sid):]...)
+
+	default:
+		ace.Raw = append([]byte(nil), b...)
+	}
+
+	return ace, nil
+}
+
+func (acl *ACL) encode() []byte {
+	out := make([]byte, 8)
+	out[0] = acl.Revision
+	for _, ace := range acl.Aces {
+		out = append(out, ace.encode()...)
+	}
+
+	binary.LittleEndian.PutUint16(out[2:4], uint16(len(out)))
+	binary.LittleEndian.PutUint16(out[4:6], uint16(len(acl.Aces)))
+	return out
+}
+
+func (ace *ACE) encode() []byte {
+	if ace.Raw != nil {
+		return ace.Raw
+	}
+
+	out := make([]byte, 8)
+	out[0] = ace.Type
+	out[1] = ace.Flags
+	binary.LittleEndian.PutUint32(out[4:8], ace.Mask)
+
+	if ace.Type == AccessAllowedObjectACEType || ace.Type == AccessDeniedObjectACEType {
+		flags := make([]byte, 4)
+		binary.LittleEndian.PutUint32(flags, ace.ObjectFlags)
+		out = append(out, flags...)
+		if ace.ObjectFlags&ACEObjectTypePresent != 0 {
+			out = append(out, ace.ObjectType[:]...)
+		}
+		if ace.ObjectFlags&ACEInheritedObjectTypePresent != 0 {
+			out = append(out, ace.InheritedObjectType[:]...)
+		}
+	}
+
+	out = append(out, ace.SID...)
+	out = append(out, ace.ApplicationData...)
+	for len(out)%4 != 0 {
+		out = append(out, 0)
+	}
+
+	binary.LittleEndian.PutUint16(out[2:4], uint16(len(out)))
+	return out
+}
@@ -0,0 +1,144 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// O:BAG:BAD:(A;;RPWPCCDCLCSWRCWDWOGA;;;S-1-0-0), the example from MS-DTYP 2.4.6
+const exampleSD = "01000480300000004000000000000000140000000200" +
+	"1c000100000000001400" + "3f000e10" + "010100000000000000000000" +
+	"0102000000000005200000002002000001020000000000052000000020020000"
+
+func TestDecodeSecurityDescriptor(t *testing.T) {
+	b, _ := hex.DecodeString(exampleSD)
+
+	sd, err := DecodeSecurityDescriptor(b)
+	if err != nil {
+		t.Fatalf("DecodeSecurityDescriptor: %s", err)
+	}
+
+	if sd.Revision != 1 || sd.Control != SEDaclPresent|SESelfRelative {
+		t.Errorf("revision %d control %#x", sd.Revision, sd.Control)
+	}
+
+	if owner, _ := DecodeSID(sd.Owner); owner.String() != "S-1-5-32-544" {
+		t.Errorf("owner %s", owner)
+	}
+
+	if group, _ := DecodeSID(sd.Group); group.String() != "S-1-5-32-544" {
+		t.Errorf("group %s", group)
+	}
+
+	if sd.Sacl != nil {
+		t.Errorf("unexpected sacl")
+	}
+
+	if sd.Dacl == nil || len(sd.Dacl.Aces) != 1 {
+		t.Fatalf("expected one ace, got %+v", sd.Dacl)
+	}
+
+	ace := sd.Dacl.Aces[0]
+	if ace.Type != AccessAllowedACEType || ace.Flags != 0 || ace.Mask != 0x100e003f {
+		t.Errorf("ace type %d flags %#x mask %#x", ace.Type, ace.Flags, ace.Mask)
+	}
+
+	if sid, _ := DecodeSID(ace.SID); sid.String() != "S-1-0-0" {
+		t.Errorf("ace sid %s", sid)
+	}
+}
+
+func TestSecurityDescriptorRoundTrip(t *testing.T) {
+	b, _ := hex.DecodeString(exampleSD)
+
+	sd, err := DecodeSecurityDescriptor(b)
+	if err != nil {
+		t.Fatalf("DecodeSecurityDescriptor: %s", err)
+	}
+
+	if out := sd.Encode(); !bytes.Equal(out, b) {
+		t.Errorf("encoded security descriptor differs\n got %x\nwant %x", out, b)
+	}
+}
+
+func TestSecurityDescriptorObjectACE(t *testing.T) {
+	member, _ := ParseGUID("bf9679c0-0de6-11d0-a285-00aa003049e2")
+	user, _ := ParseSID("S-1-5-21-3623811015-3361044348-30300820-1013")
+	everyone, _ := ParseSID("S-1-1-0")
+
+	sd := &SecurityDescriptor{
+		Revision: 1,
+		Owner:    user.Bytes(),
+		Sacl: &ACL{Revision: 4, Aces: []*ACE{
+			// audit aces are not understood and must be kept verbatim
+			{Raw: []byte{0x02, 0x40, 0x14, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x01, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}},
+		}},
+		Dacl: &ACL{Revision: 4, Aces: []*ACE{
+			{Type: AccessDeniedACEType, Mask: ADSRightDelete | ADSRightDSDeleteTree, SID: everyone.Bytes()},
+			{
+				Type:        AccessAllowedObjectACEType,
+				Flags:       ContainerInheritACE,
+				Mask:        ADSRightDSWriteProp,
+				ObjectFlags: ACEObjectTypePresent,
+				ObjectType:  [16]byte(member),
+				SID:         user.Bytes(),
+			},
+		}},
+	}
+
+	encoded := sd.Encode()
+	decoded, err := DecodeSecurityDescriptor(encoded)
+	if err != nil {
+		t.Fatalf("DecodeSecurityDescriptor: %s", err)
+	}
+
+	if !bytes.Equal(decoded.Encode(), encoded) {
+		t.Errorf("second encoding differs")
+	}
+
+	if decoded.Group != nil {
+		t.Errorf("unexpected group")
+	}
+
+	if len(decoded.Sacl.Aces) != 1 || !bytes.Equal(decoded.Sacl.Aces[0].Raw, sd.Sacl.Aces[0].Raw) {
+		t.Errorf("sacl not kept verbatim: %+v", decoded.Sacl.Aces)
+	}
+
+	if len(decoded.Dacl.Aces) != 2 {
+		t.Fatalf("expected two aces, got %d", len(decoded.Dacl.Aces))
+	}
+
+	deny := decoded.Dacl.Aces[0]
+	if deny.Type != AccessDeniedACEType || deny.Mask != ADSRightDelete|ADSRightDSDeleteTree || !bytes.Equal(deny.SID, everyone.Bytes()) {
+		t.Errorf("deny ace %+v", deny)
+	}
+
+	ace := decoded.Dacl.Aces[1]
+	if ace.Type != AccessAllowedObjectACEType || ace.Flags != ContainerInheritACE || ace.Mask != ADSRightDSWriteProp {
+		t.Errorf("object ace type %d flags %#x mask %#x", ace.Type, ace.Flags, ace.Mask)
+	}
+	if GUID(ace.ObjectType).String() != "bf9679c0-0de6-11d0-a285-00aa003049e2" {
+		t.Errorf("object type %s", GUID(ace.ObjectType))
+	}
+	if !bytes.Equal(ace.SID, user.Bytes()) {
+		t.Errorf("object ace sid %x", ace.SID)
+	}
+}
+
+func TestDecodeSecurityDescriptorInvalid(t *testing.T) {
+	b, _ := hex.DecodeString(exampleSD)
+
+	tests := map[string][]byte{
+		"empty":           nil,
+		"header only":     b[:20],
+		"truncated dacl":  b[:30],
+		"truncated group": b[:len(b)-4],
+	}
+
+	for name, input := range tests {
+		if _, err := DecodeSecurityDescriptor(input); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// SSHPublicKey is a parsed OpenSSH authorized_keys line
+type SSHPublicKey struct {
+	Options string
+	Type    string
+	Blob    []byte
+	Comment string
+
+	// key size in bits for rsa, dsa and ecdsa keys, 0 otherwise
+	Bits int
+}
+
+// SSHKeyPolicy restricts which keys are accepted
+type SSHKeyPolicy struct {
+	AllowedTypes []string
+	MinRSABits   int
+}
+
+// DefaultSSHKeyPolicy allows ed25519, ecdsa, security key and 2048+ bit rsa keys
+var DefaultSSHKeyPolicy = &SSHKeyPolicy{
+	AllowedTypes: []string{
+		"ssh-ed25519",
+		"ecdsa-sha2-nistp256",
+		"ecdsa-sha2-nistp384",
+		"ecdsa-sha2-nistp521",
+		"sk-ssh-ed25519@openssh.com",
+		"sk-ecdsa-sha2-nistp256@openssh.com",
+		"ssh-rsa",
+	},
+	MinRSABits: 2048,
+}
+
+var sshKeyTypes = map[string]bool{
+	"ssh-rsa":                            true,
+	"ssh-dss":                            true,
+	"ssh-ed25519":                        true,
+	"ecdsa-sha2-nistp256":                true,
+	"ecdsa-sha2-nistp384":                true,
+	"ecdsa-sha2-nistp521":                true,
+	"sk-ssh-ed25519@openssh.com":         true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// ParseSSHPublicKey parses a key in OpenSSH authorized_keys format: [options] type base64 [comment]
+func ParseSSHPublicKey(line string) (*SSHPublicKey, error) {
+	fields := strings.Fields(strings.TrimSpace(line))
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("not an ssh public key")
+	}
+
+	key := &SSHPublicKey{}
+
+	// anything before a known key type are options
+	start := -1
+	for i, f := range fields {
+		if sshKeyTypes[f] {
+			start = i
+			break
+		}
+	}
+	if start < 0 || start+1 >= len(fields) {
+		return nil, fmt.Errorf("unknown or missing ssh key type")
+	}
+
+	key.Options = strings.Join(fields[:start], " ")
+	key.Type = fields[start]
+	key.Comment = strings.Join(fields[start+2:], " ")
+
+	blob, err := base64.StdEncoding.DecodeString(fields[start+1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 key data: %s", err)
+	}
+	key.Blob = blob
+
+	parts, err := readSSHStrings(blob)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 || string(parts[0]) != key.Type {
+		return nil, fmt.Errorf("key data does not match key type %s", key.Type)
+	}
+
+	switch key.Type {
+	case "ssh-rsa":
+		// string type, mpint e, mpint n
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("malformed rsa key")
+		}
+		key.Bits = new(big.Int).SetBytes(parts[2]).BitLen()
+	case "ssh-dss":
+		// string type, mpint p, q, g, y
+		if len(parts) != 5 {
+			return nil, fmt.Errorf("malformed dsa key")
+		}
+		key.Bits = new(big.Int).SetBytes(parts[1]).BitLen()
+	case "ecdsa-sha2-nistp256", "sk-ecdsa-sha2-nistp256@openssh.com":
+		key.Bits = 256
+	case "ecdsa-sha2-nistp384":
+		key.Bits = 384
+	case "ecdsa-sha2-nistp521":
+		key.Bits = 521
+	}
+
+	return key, nil
+}
+
+// splits ssh wire format data into its length prefixed strings
+func readSSHStrings(b []byte) ([][]byte, error) {
+	var parts [][]byte
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("truncated key data")
+		}
+		n := binary.BigEndian.Uint32(b)
+		if uint32(len(b)-4) < n {
+			return nil, fmt.Errorf("truncated key data")
+		}
+		parts = append(parts, b[4:4+n])
+		b = b[4+n:]
+	}
+	return parts, nil
+}
+
+// Fingerprint returns the OpenSSH SHA256 fingerprint of the key
+func (k *SSHPublicKey) Fingerprint() string {
+	sum := sha256.Sum256(k.Blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// String returns the key in authorized_keys format
+func (k *SSHPublicKey) String() string {
+	parts := []string{}
+	if k.Options != "" {
+		parts = append(parts, k.Options)
+	}
+	parts = append(parts, k.Type, base64.StdEncoding.EncodeToString(k.Blob))
+	if k.Comment != "" {
+		parts = append(parts, k.Comment)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Check verifies the key against the policy
+func (p *SSHKeyPolicy) Check(k *SSHPublicKey) error {
+	allowed := false
+	for _, t := range p.AllowedTypes {
+		if t == k.Type {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("ssh key type %s is not allowed", k.Type)
+	}
+
+	if k.Type == "ssh-rsa" && k.Bits < p.MinRSABits {
+		return fmt.Errorf("rsa key has %d bits, at least %d are required", k.Bits, p.MinRSABits)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package helper
+
+import "testing"
+
+const (
+	testEd25519Key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIkcaWxPHhxtepefu19NPpni0HthoVYLcwLhph8vtR6D"
+	testRSA1024Key = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQCkNZQC92f4IOfg5WHlnmhoGwboElUhSFXIpLlCHbP7f7ShpYvXvZ6RpAOmYUHEIQWiyLEN9QsA0RqIpkxFlOJ+Vs8HgTcjv2uuff6GnQkE2xoRIEf+ULkJM2LdTPysfGPm6Z4NNCbUW7K+lbTnqx6+IfeHvAVM4PEWSVrLaR/y1w=="
+	testECDSAKey   = "ecdsa-sha2-nistp384 AAAAE2VjZHNhLXNoYTItbmlzdHAzODQAAAAIbmlzdHAzODQAAABhBEwOxmaH2tp7Ju5QgvlvzbqFWz7WLQR5aH4DCwtc3ZAie2Qp6wymO2jiHaxPHLTKuvkh157+Jem80F9NiFvgp7af2Em0I7O7fvDl0AGWf9+lQsWIa6imfF/EAPA5oTYssw=="
+)
+
+func TestParseSSHPublicKey(t *testing.T) {
+	tests := []struct {
+		line        string
+		keyType     string
+		options     string
+		comment     string
+		bits        int
+		fingerprint string
+	}{
+		{
+			line:        testEd25519Key + " alice@example.com",
+			keyType:     "ssh-ed25519",
+			comment:     "alice@example.com",
+			fingerprint: "SHA256:cjZ25x1VcjmzZf0zPdAKRVWLKDH2eCkNTX+qzm7JyzA",
+		},
+		{
+			line:        testRSA1024Key,
+			keyType:     "ssh-rsa",
+			bits:        1024,
+			fingerprint: "SHA256:g3Vfkd3/UEwTxZ2FNzrKhaVubi8RkaOLFszuUQzw8N4",
+		},
+		{
+			line:        "  " + testECDSAKey + " build server  \n",
+			keyType:     "ecdsa-sha2-nistp384",
+			comment:     "build server",
+			bits:        384,
+			fingerprint: "SHA256:YPqoDPEKHLLSLLafAxT5ebS5a5EPPxKDjvsxcIgK7yY",
+		},
+		{
+			line:        `from="10.0.0.0/8",no-pty ` + testEd25519Key + " alice@example.com",
+			keyType:     "ssh-ed25519",
+			options:     `from="10.0.0.0/8",no-pty`,
+			comment:     "alice@example.com",
+			fingerprint: "SHA256:cjZ25x1VcjmzZf0zPdAKRVWLKDH2eCkNTX+qzm7JyzA",
+		},
+	}
+
+	for _, tt := range tests {
+		key, err := ParseSSHPublicKey(tt.line)
+		if err != nil {
+			t.Errorf("ParseSSHPublicKey(%q): %s", tt.line, err)
+			continue
+		}
+		if key.Type != tt.keyType || key.Options != tt.options || key.Comment != tt.comment || key.Bits != tt.bits {
+			t.Errorf("ParseSSHPublicKey(%q) = type %q options %q comment %q bits %d, want %q %q %q %d",
+				tt.line, key.Type, key.Options, key.Comment, key.Bits, tt.keyType, tt.options, tt.comment, tt.bits)
+		}
+		if fp := key.Fingerprint(); fp != tt.fingerprint {
+			t.Errorf("Fingerprint(%q) = %s, want %s", tt.line, fp, tt.fingerprint)
+		}
+
+		again, err := ParseSSHPublicKey(key.String())
+		if err != nil || again.String() != key.String() {
+			t.Errorf("String(%q) does not round trip: %q, %v", tt.line, key.String(), err)
+		}
+	}
+}
+
+func TestParseSSHPublicKeyInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"ssh-ed25519",
+		"AAAAC3NzaC1lZDI1NTE5AAAAIIkcaWxPHhxtepefu19NPpni0HthoVYLcwLhph8vtR6D alice",
+		"ssh-foo AAAAC3NzaC1lZDI1NTE5AAAAIIkcaWxPHhxtepefu19NPpni0HthoVYLcwLhph8vtR6D",
+		"ssh-ed25519 not-base64!",
+		// ed25519 key data labelled as rsa
+		"ssh-rsa AAAAC3NzaC1lZDI1NTE5AAAAIIkcaWxPHhxtepefu19NPpni0HthoVYLcwLhph8vtR6D",
+		// truncated key data
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIkcaWxPHhxtepefu19NPpni0Htho",
+	}
+
+	for _, line := range tests {
+		if key, err := ParseSSHPublicKey(line); err == nil {
+			t.Errorf("ParseSSHPublicKey(%q) = %v, want an error", line, key)
+		}
+	}
+}
+
+// duplicates are detected by fingerprint, so options and comments must not matter
+func TestSSHFingerprintIgnoresOptionsAndComment(t *testing.T) {
+	a, err := ParseSSHPublicKey(testEd25519Key + " alice@laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseSSHPublicKey(`no-agent-forwarding ` + testEd25519Key + " alice@desktop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseSSHPublicKey(testECDSAKey + " alice@laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Errorf("same key has fingerprints %s and %s", a.Fingerprint(), b.Fingerprint())
+	}
+	if a.Fingerprint() == c.Fingerprint() {
+		t.Errorf("different keys share fingerprint %s", a.Fingerprint())
+	}
+}
+
+func TestSSHKeyPolicyCheck(t *testing.T) {
+	tests := []struct {
+		line   string
+		policy *SSHKeyPolicy
+		ok     bool
+	}{
+		{testEd25519Key, DefaultSSHKeyPolicy, true},
+		{testECDSAKey, DefaultSSHKeyPolicy, true},
+		{testRSA1024Key, DefaultSSHKeyPolicy, false},
+		{testRSA1024Key, &SSHKeyPolicy{AllowedTypes: []string{"ssh-rsa"}, MinRSABits: 1024}, true},
+		{testEd25519Key, &SSHKeyPolicy{AllowedTypes: []string{"ssh-rsa"}}, false},
+	}
+
+	for _, tt := range tests {
+		key, err := ParseSSHPublicKey(tt.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.policy.Check(key); (err == nil) != tt.ok {
+			t.Errorf("Check(%s) = %v, want ok %t", key.Type, err, tt.ok)
+		}
+	}
+}
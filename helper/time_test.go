@@ -0,0 +1,91 @@
+package helper
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFileTimeToTime(t *testing.T) {
+	tests := []struct {
+		ft   int64
+		want time.Time
+	}{
+		{0, time.Time{}},
+		{-1, time.Time{}},
+		{NeverExpires, time.Time{}},
+		{116444736000000000, time.Unix(0, 0).UTC()},
+		{116444736000000001, time.Unix(0, 100).UTC()},
+		{133000000000000000, time.Date(2022, 6, 18, 4, 26, 40, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		if got := FileTimeToTime(tt.ft); !got.Equal(tt.want) || got.IsZero() != tt.want.IsZero() {
+			t.Errorf("FileTimeToTime(%d) = %s, want %s", tt.ft, got, tt.want)
+		}
+	}
+}
+
+func TestTimeToFileTime(t *testing.T) {
+	if ft := TimeToFileTime(time.Time{}); ft != 0 {
+		t.Errorf("TimeToFileTime(zero) = %d, want 0", ft)
+	}
+
+	for _, tm := range []time.Time{
+		time.Unix(0, 0).UTC(),
+		time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC),
+		time.Date(2026, 10, 18, 12, 34, 56, 789012300, time.UTC),
+		time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		ft := TimeToFileTime(tm)
+		if got := FileTimeToTime(ft); !got.Equal(tm) {
+			t.Errorf("round trip of %s via %d = %s", tm, ft, got)
+		}
+
+		parsed, err := ParseFileTime(strconv.FormatInt(ft, 10))
+		if err != nil || !parsed.Equal(tm) {
+			t.Errorf("ParseFileTime(%d) = %s, %v", ft, parsed, err)
+		}
+	}
+
+	// sub 100ns precision is truncated
+	tm := time.Date(2026, 1, 1, 0, 0, 0, 150, time.UTC)
+	if got := FileTimeToTime(TimeToFileTime(tm)); !got.Equal(tm.Truncate(100)) {
+		t.Errorf("round trip of %s = %s", tm, got)
+	}
+}
+
+func TestParseFileTime(t *testing.T) {
+	for _, s := range []string{"", "0", "9223372036854775807"} {
+		got, err := ParseFileTime(s)
+		if err != nil || !got.IsZero() {
+			t.Errorf("ParseFileTime(%q) = %s, %v, want the zero time", s, got, err)
+		}
+	}
+
+	for _, s := range []string{"abc", "1.5", "9223372036854775808"} {
+		if _, err := ParseFileTime(s); err == nil {
+			t.Errorf("ParseFileTime(%q): expected an error", s)
+		}
+	}
+}
+
+func TestParseGeneralizedTime(t *testing.T) {
+	got, err := ParseGeneralizedTime("20240229031505.0Z")
+	if err != nil {
+		t.Fatalf("ParseGeneralizedTime: %s", err)
+	}
+	if want := time.Date(2024, 2, 29, 3, 15, 5, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("ParseGeneralizedTime = %s, want %s", got, want)
+	}
+
+	if got, err := ParseGeneralizedTime(""); err != nil || !got.IsZero() {
+		t.Errorf("ParseGeneralizedTime(\"\") = %s, %v", got, err)
+	}
+
+	for _, s := range []string{"2024-02-29", "20240230000000.0Z", "20240229031505Z"} {
+		if _, err := ParseGeneralizedTime(s); err == nil {
+			t.Errorf("ParseGeneralizedTime(%q): expected an error", s)
+		}
+	}
+}